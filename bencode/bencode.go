@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"sort"
+	"sync/atomic"
 )
 
 type BType uint8
@@ -79,9 +81,15 @@ func (o *BObject) Bencode(w io.Writer) int {
 	case BDICT:
 		bw.WriteByte('d')
 		dict, _ := o.Dict()
-		for k, v := range dict {
+		// Keys must be sorted for the encoding to be canonical
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
 			wLen += EncodeString(bw, k)
-			wLen += v.Bencode(bw)
+			wLen += dict[k].Bencode(bw)
 		}
 		bw.WriteByte('e')
 		wLen += 2
@@ -90,7 +98,22 @@ func (o *BObject) Bencode(w io.Writer) int {
 	return wLen
 }
 
+var decodeErrors atomic.Int64
+
+// DecodeErrors returns how many calls to Bdecode have failed
+func DecodeErrors() int64 {
+	return decodeErrors.Load()
+}
+
 func Bdecode(r io.Reader) (*BObject, []byte, error) {
+	o, raw, err := bdecode(r)
+	if err != nil {
+		decodeErrors.Add(1)
+	}
+	return o, raw, err
+}
+
+func bdecode(r io.Reader) (*BObject, []byte, error) {
 	br, ok := r.(*bufio.Reader)
 	if !ok {
 		br = bufio.NewReader(r)
@@ -133,7 +156,7 @@ func Bdecode(r io.Reader) (*BObject, []byte, error) {
 				raw_ = append(raw_, b)
 				break
 			}
-			elem, raw, err := Bdecode(br)
+			elem, raw, err := bdecode(br)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -159,7 +182,7 @@ func Bdecode(r io.Reader) (*BObject, []byte, error) {
 			if err != nil {
 				return nil, nil, err
 			}
-			val, raw, err := Bdecode(br)
+			val, raw, err := bdecode(br)
 			raw_ = append(raw_, raw...)
 			if err != nil {
 				return nil, nil, err
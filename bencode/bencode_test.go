@@ -211,6 +211,7 @@ func TestDecodeMap(t *testing.T) {
 
 	out := bytes.NewBufferString("")
 	assert.Equal(t, len(in), o.Bencode(out))
+	assert.Equal(t, "d3:agei29e4:name6:archere", out.String())
 }
 
 func TestDecodeComMap(t *testing.T) {
@@ -226,3 +227,15 @@ func TestDecodeComMap(t *testing.T) {
 	assert.Equal(t, BDICT, dict["user"].type_)
 	assert.Equal(t, BLIST, dict["value"].type_)
 }
+
+func TestDecodeErrors(t *testing.T) {
+	before := DecodeErrors()
+	_, _, err := Bdecode(bytes.NewBufferString("x"))
+	assert.NotNil(t, err)
+	// A nested failure is counted once
+	_, _, err = Bdecode(bytes.NewBufferString("lli1ex"))
+	assert.NotNil(t, err)
+	_, _, err = Bdecode(bytes.NewBufferString("i1e"))
+	assert.Nil(t, err)
+	assert.Equal(t, before+2, DecodeErrors())
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -109,10 +110,7 @@ func unmarshalDict(p reflect.Value, dict map[string]*BObject) error {
 			continue
 		}
 		ft := v.Type().Field(i)
-		key := ft.Tag.Get("bencode")
-		if key == "" {
-			key = strings.ToLower(ft.Name)
-		}
+		key, _ := fieldKey(ft)
 		fo := dict[key]
 		if fo == nil {
 			continue
@@ -170,6 +168,13 @@ func marshalValue(w io.Writer, v reflect.Value) (int, error) {
 		len += marshalList(w, v)
 	case reflect.Struct:
 		len += marshalDict(w, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return -1, errors.New("map keys must be strings")
+		}
+		len += marshalMap(w, v)
+	case reflect.Interface:
+		return marshalValue(w, v.Elem())
 	default:
 		return -1, errors.New("unsupport type")
 	}
@@ -188,15 +193,27 @@ func marshalList(w io.Writer, vl reflect.Value) int {
 	return len
 }
 
+// fieldKey returns the dict key of a struct field and whether the field
+// is left out when it holds its zero value, as in `bencode:"key,omitempty"`.
+// Fields are written in the order they are declared, so structs that are
+// marshalled must declare them sorted by key.
+func fieldKey(ft reflect.StructField) (key string, omitEmpty bool) {
+	key, opts, _ := strings.Cut(ft.Tag.Get("bencode"), ",")
+	if key == "" {
+		key = strings.ToLower(ft.Name)
+	}
+	return key, opts == "omitempty"
+}
+
 func marshalDict(w io.Writer, vd reflect.Value) int {
 	len := 2
 	w.Write([]byte{'d'})
 	for i := 0; i < vd.NumField(); i++ {
 		fv := vd.Field(i)
 		ft := vd.Type().Field(i)
-		key := ft.Tag.Get("bencode")
-		if key == "" {
-			key = strings.ToLower(ft.Name)
+		key, omitEmpty := fieldKey(ft)
+		if omitEmpty && fv.IsZero() {
+			continue
 		}
 		len += EncodeString(w, key)
 		l, _ := marshalValue(w, fv)
@@ -206,6 +223,22 @@ func marshalDict(w io.Writer, vd reflect.Value) int {
 	return len
 }
 
+// marshalMap writes a map as a dict, with its keys sorted as bencode
+// requires
+func marshalMap(w io.Writer, vm reflect.Value) int {
+	len := 2
+	w.Write([]byte{'d'})
+	keys := vm.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, k := range keys {
+		len += EncodeString(w, k.String())
+		l, _ := marshalValue(w, vm.MapIndex(k))
+		len += l
+	}
+	w.Write([]byte{'e'})
+	return len
+}
+
 func Marshal(w io.Writer, s interface{}) (int, error) {
 	v := reflect.ValueOf(s)
 	if v.Kind() == reflect.Ptr {
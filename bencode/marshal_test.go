@@ -112,3 +112,38 @@ func TestUnmarshalTeam(t *testing.T) {
 	assert.Equal(t, len(str), length)
 	assert.Equal(t, str, buf.String())
 }
+
+type Release struct {
+	Comment string   `bencode:"comment,omitempty"`
+	Name    string   `bencode:"name"`
+	Private int      `bencode:"private,omitempty"`
+	Tags    []string `bencode:"tags,omitempty"`
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	length, err := Marshal(buf, Release{Name: "v1", Tags: []string{"a"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "d4:name2:v14:tagsl1:aee", buf.String())
+	assert.Equal(t, buf.Len(), length)
+
+	r := &Release{}
+	o, _, _ := Bdecode(bytes.NewBufferString("d7:comment2:hi4:name2:v17:privatei1ee"))
+	Unmarshal(o, r)
+	assert.Equal(t, Release{Comment: "hi", Name: "v1", Private: 1}, *r)
+}
+
+func TestMarshalMap(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tree := map[string]any{
+		"b": map[string]any{"": map[string]any{"length": 3}},
+		"a": "x",
+	}
+	length, err := Marshal(buf, tree)
+	assert.Nil(t, err)
+	assert.Equal(t, "d1:a1:x1:bd0:d6:lengthi3eeee", buf.String())
+	assert.Equal(t, buf.Len(), length)
+
+	_, err = Marshal(buf, map[int]string{1: "a"})
+	assert.NotNil(t, err)
+}
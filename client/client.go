@@ -2,11 +2,15 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/parkma99/go-bittorrent-client/peers"
+	"github.com/parkma99/go-bittorrent-client/ratelimit"
 )
 
 type bitfield []byte
@@ -27,18 +31,27 @@ func (bf bitfield) setPiece(index int) {
 // A Client is a TCP connection with a peer
 type client struct {
 	conn     net.Conn
-	choked   bool
+	choked   atomic.Bool
 	bitfield bitfield
 	peer     peers.Peer
 	infoHash [20]byte
 	peerID   [20]byte
+	remoteID [20]byte
+	stats    *transferStats
+	log      *slog.Logger
+	// v2 answers hash requests, nil for a v1 torrent
+	v2 *layoutV2
+
+	// Per-connection limiters, chained with the shared ones in conn
+	upLimit   *ratelimit.Limiter
+	downLimit *ratelimit.Limiter
 }
 
-func completeHandshake(conn net.Conn, infohash, peerID [20]byte) (*handshake, error) {
+func completeHandshake(conn net.Conn, req *handshake) (*handshake, error) {
 	conn.SetDeadline(time.Now().Add(3 * time.Second))
 	defer conn.SetDeadline(time.Time{}) // Disable the deadline
 
-	req := newHandshake(infohash, peerID)
+	infohash := req.InfoHash
 	_, err := conn.Write(req.serialize())
 	if err != nil {
 		return nil, err
@@ -70,45 +83,142 @@ func recvBitfield(conn net.Conn) (bitfield, error) {
 	return msg.Payload, nil
 }
 
+// Dialer opens connections to peers. *net.Dialer satisfies it, as do the
+// proxies in the proxy package.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// clientConfig holds what a connection needs to know about the torrent
+// and the session it belongs to
+type clientConfig struct {
+	dialer   Dialer
+	peerID   [20]byte
+	infoHash [20]byte
+	limits   RateLimits
+	stats    *transferStats
+	log      *slog.Logger
+	v2       *layoutV2
+}
+
+// handshake returns the handshake we send, which announces v2 support
+// for a v2 torrent
+func (cfg clientConfig) handshake() *handshake {
+	h := newHandshake(cfg.infoHash, cfg.peerID)
+	if cfg.v2 != nil {
+		h.Reserved[7] |= reservedV2
+	}
+	return h
+}
+
 // New connects with a peer, completes a handshake, and receives a handshake
-// returns an err if any of those fail.
-func newClient(peer peers.Peer, peerID, infoHash [20]byte) (*client, error) {
-	conn, err := net.DialTimeout("tcp", peer.String(), 3*time.Second)
+// returns an err if any of those fail. All traffic on the connection is
+// throttled by cfg.limits and counted in cfg.stats.
+func newClient(ctx context.Context, peer peers.Peer, cfg clientConfig) (*client, error) {
+	dialer := cfg.dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	rawConn, err := dialer.DialContext(ctx, "tcp", peer.String())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	c := cfg.wrap(rawConn, peer)
+
+	h, err := completeHandshake(c.conn, cfg.handshake())
 	if err != nil {
+		c.conn.Close()
 		return nil, err
 	}
+	c.remoteID = h.PeerID
 
-	_, err = completeHandshake(conn, infoHash, peerID)
+	c.bitfield, err = recvBitfield(c.conn)
 	if err != nil {
-		conn.Close()
+		c.conn.Close()
 		return nil, err
 	}
+	return c, nil
+}
+
+// acceptClient takes over a connection opened by a remote peer whose
+// handshake has already been read, answers the handshake and receives
+// the bitfield
+func acceptClient(rawConn net.Conn, peer peers.Peer, remoteID [20]byte, cfg clientConfig) (*client, error) {
+	c := cfg.wrap(rawConn, peer)
+	c.remoteID = remoteID
 
-	bf, err := recvBitfield(conn)
+	c.conn.SetDeadline(time.Now().Add(3 * time.Second))
+	_, err := c.conn.Write(cfg.handshake().serialize())
+	c.conn.SetDeadline(time.Time{})
 	if err != nil {
-		conn.Close()
+		c.conn.Close()
 		return nil, err
 	}
 
-	return &client{
-		conn:     conn,
-		choked:   true,
-		bitfield: bf,
-		peer:     peer,
-		infoHash: infoHash,
-		peerID:   peerID,
-	}, nil
+	c.bitfield, err = recvBitfield(c.conn)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (cfg clientConfig) wrap(rawConn net.Conn, peer peers.Peer) *client {
+	upLimit := ratelimit.New(cfg.limits.PeerUpload)
+	downLimit := ratelimit.New(cfg.limits.PeerDownload)
+	conn := &meteredConn{
+		Conn:  rawConn,
+		up:    []*ratelimit.Limiter{upLimit, cfg.limits.TorrentUpload, cfg.limits.SessionUpload},
+		down:  []*ratelimit.Limiter{downLimit, cfg.limits.TorrentDownload, cfg.limits.SessionDownload},
+		stats: cfg.stats,
+	}
+	c := &client{
+		conn:      conn,
+		peer:      peer,
+		infoHash:  cfg.infoHash,
+		peerID:    cfg.peerID,
+		stats:     cfg.stats,
+		log:       cfg.log,
+		v2:        cfg.v2,
+		upLimit:   upLimit,
+		downLimit: downLimit,
+	}
+	c.choked.Store(true)
+	return c
+}
+
+// ReadIncomingHandshake reads the handshake a remote peer sends after
+// opening a connection to us. The connection can then be handed to the
+// Torrent the peer asked for with AddConn.
+func ReadIncomingHandshake(conn net.Conn) (infoHash, peerID [20]byte, err error) {
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetDeadline(time.Time{}) // Disable the deadline
+
+	h, err := readHandshake(conn)
+	if err != nil {
+		return infoHash, peerID, err
+	}
+	return h.InfoHash, h.PeerID, nil
 }
 
 // Read reads and consumes a message from the connection
 func (c *client) read() (*message, error) {
 	msg, err := readMessage(c.conn)
+	if err == nil {
+		c.trace("received", msg)
+	}
+	if err == nil && msg != nil && msg.ID == msgPiece && len(msg.Payload) > 8 && c.stats != nil {
+		c.stats.payloadRead.Add(int64(len(msg.Payload) - 8))
+	}
 	return msg, err
 }
 
 // SendRequest sends a Request message to the peer
 func (c *client) sendRequest(index, begin, length int) error {
 	req := formatRequest(index, begin, length)
+	c.trace("sent", req)
 	_, err := c.conn.Write(req.serialize())
 	return err
 }
@@ -116,6 +226,7 @@ func (c *client) sendRequest(index, begin, length int) error {
 // SendInterested sends an Interested message to the peer
 func (c *client) sendInterested() error {
 	msg := message{ID: msgInterested}
+	c.trace("sent", &msg)
 	_, err := c.conn.Write(msg.serialize())
 	return err
 }
@@ -123,6 +234,7 @@ func (c *client) sendInterested() error {
 // SendNotInterested sends a NotInterested message to the peer
 func (c *client) sendNotInterested() error {
 	msg := message{ID: msgNotInterested}
+	c.trace("sent", &msg)
 	_, err := c.conn.Write(msg.serialize())
 	return err
 }
@@ -130,6 +242,7 @@ func (c *client) sendNotInterested() error {
 // SendUnchoke sends an Unchoke message to the peer
 func (c *client) sendUnchoke() error {
 	msg := message{ID: msgUnchoke}
+	c.trace("sent", &msg)
 	_, err := c.conn.Write(msg.serialize())
 	return err
 }
@@ -137,6 +250,7 @@ func (c *client) sendUnchoke() error {
 // SendHave sends a Have message to the peer
 func (c *client) sendHave(index int) error {
 	msg := formatHave(index)
+	c.trace("sent", msg)
 	_, err := c.conn.Write(msg.serialize())
 	return err
 }
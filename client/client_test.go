@@ -92,7 +92,7 @@ func TestCompleteHandshake(t *testing.T) {
 		clientConn, serverConn := createClientAndServer(t)
 		serverConn.Write(test.serverHandshake)
 
-		h, err := completeHandshake(clientConn, test.clientInfohash, test.clientPeerID)
+		h, err := completeHandshake(clientConn, newHandshake(test.clientInfohash, test.clientPeerID))
 
 		if test.fails {
 			assert.NotNil(t, err)
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/peers"
+)
+
+// Event is something that happened during a download. Subscribers tell
+// the concrete types apart with a type switch.
+type Event interface {
+	isEvent()
+}
+
+// PieceVerified is emitted when a piece passed its integrity check
+type PieceVerified struct {
+	Index int
+	Peer  peers.Peer
+}
+
+// PieceFailed is emitted when a piece failed its integrity check or
+// could not be downloaded from a peer
+type PieceFailed struct {
+	Index int
+	Peer  peers.Peer
+	Err   error
+}
+
+// PeerConnected is emitted after the handshake with a peer completed.
+// Client is guessed from the peer ID the peer sent.
+type PeerConnected struct {
+	Peer   peers.Peer
+	Client ClientInfo
+}
+
+// PeerDisconnected is emitted when the connection to a peer is closed.
+// Err is nil when we closed it ourselves.
+type PeerDisconnected struct {
+	Peer peers.Peer
+	Err  error
+}
+
+// PeerBanned is emitted when a peer is banned for sending corrupt data.
+// Connections to it are closed and no new ones are made.
+type PeerBanned struct {
+	Peer peers.Peer
+}
+
+// TrackerAnnounce is emitted after every request to a tracker
+type TrackerAnnounce struct {
+	URL      string
+	Event    string
+	Peers    int
+	Duration time.Duration
+	Err      error
+}
+
+// Progress is emitted after every verified piece and once per second
+type Progress struct {
+	DonePieces     int
+	TotalPieces    int
+	Peers          int
+	BytesPerSecond float64
+	// ETA is zero while the download rate is unknown
+	ETA time.Duration
+}
+
+func (PieceVerified) isEvent()    {}
+func (PieceFailed) isEvent()      {}
+func (PeerConnected) isEvent()    {}
+func (PeerDisconnected) isEvent() {}
+func (PeerBanned) isEvent()       {}
+func (TrackerAnnounce) isEvent()  {}
+func (Progress) isEvent()         {}
+
+// Bus delivers events to subscribers. Subscribers are called one event at
+// a time, in the order the events were published, and must not block or
+// call back into the Bus. The zero value is ready to use and a nil *Bus
+// drops every event.
+type Bus struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]func(Event)
+}
+
+// Subscribe calls fn for every event published from now on until the
+// returned function is called
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[int]func(Event))
+	}
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish delivers e to every subscriber
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, fn := range b.subs {
+		fn(e)
+	}
+}
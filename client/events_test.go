@@ -0,0 +1,24 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := &Bus{}
+	var first, second []Event
+	unsubscribe := bus.Subscribe(func(e Event) { first = append(first, e) })
+	bus.Subscribe(func(e Event) { second = append(second, e) })
+
+	bus.Publish(PieceVerified{Index: 1})
+	unsubscribe()
+	bus.Publish(PieceVerified{Index: 2})
+
+	assert.Equal(t, []Event{PieceVerified{Index: 1}}, first)
+	assert.Equal(t, []Event{PieceVerified{Index: 1}, PieceVerified{Index: 2}}, second)
+
+	var nilBus *Bus
+	assert.NotPanics(t, func() { nilBus.Publish(Progress{}) })
+}
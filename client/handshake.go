@@ -7,10 +7,15 @@ import (
 
 type handshake struct {
 	Pstr     string
+	Reserved [8]byte
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
 
+// reservedV2 is the bit of the last reserved byte that announces support
+// for BitTorrent v2 (BEP 52)
+const reservedV2 = 0x10
+
 // New creates a new handshake with the standard pstr
 func newHandshake(infoHash, peerID [20]byte) *handshake {
 	return &handshake{
@@ -27,7 +32,7 @@ func (h *handshake) serialize() []byte {
 	buf := make([]byte, bufLen)
 	buf[0] = byte(pstrlen)
 	copy(buf[1:], h.Pstr)
-	// Leave 8 reserved bytes
+	copy(buf[1+pstrlen:], h.Reserved[:])
 	copy(buf[1+pstrlen+8:], h.InfoHash[:])
 	copy(buf[1+pstrlen+8+20:], h.PeerID[:])
 	return buf
@@ -52,13 +57,16 @@ func readHandshake(r io.Reader) (*handshake, error) {
 		return nil, err
 	}
 
+	var reserved [8]byte
 	var infoHash, peerID [20]byte
 
+	copy(reserved[:], handshakeBuf[pstrlen:pstrlen+8])
 	copy(infoHash[:], handshakeBuf[pstrlen+8:pstrlen+8+20])
 	copy(peerID[:], handshakeBuf[pstrlen+8+20:])
 
 	h := handshake{
 		Pstr:     string(handshakeBuf[0:pstrlen]),
+		Reserved: reserved,
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/parkma99/go-bittorrent-client/ratelimit"
+)
+
+// RateLimits configures the bandwidth a torrent may use. Session and
+// torrent limiters are shared between all connections that reference them,
+// PeerUpload and PeerDownload are the per-connection rates in bytes per
+// second. Zero or nil means unlimited.
+type RateLimits struct {
+	SessionUpload   *ratelimit.Limiter
+	SessionDownload *ratelimit.Limiter
+	TorrentUpload   *ratelimit.Limiter
+	TorrentDownload *ratelimit.Limiter
+	PeerUpload      int
+	PeerDownload    int
+}
+
+// Stats counts the bytes exchanged with peers. Payload is piece data,
+// overhead is everything else on the wire: handshakes, message framing
+// and control messages.
+type Stats struct {
+	PayloadDownloaded  int64
+	PayloadUploaded    int64
+	OverheadDownloaded int64
+	OverheadUploaded   int64
+}
+
+type transferStats struct {
+	read           atomic.Int64
+	written        atomic.Int64
+	payloadRead    atomic.Int64
+	payloadWritten atomic.Int64
+}
+
+func (s *transferStats) snapshot() Stats {
+	read, written := s.read.Load(), s.written.Load()
+	payloadRead, payloadWritten := s.payloadRead.Load(), s.payloadWritten.Load()
+	return Stats{
+		PayloadDownloaded:  payloadRead,
+		PayloadUploaded:    payloadWritten,
+		OverheadDownloaded: read - payloadRead,
+		OverheadUploaded:   written - payloadWritten,
+	}
+}
+
+// meteredConn throttles a connection through a chain of limiters and
+// counts the bytes passing through it
+type meteredConn struct {
+	net.Conn
+	up    []*ratelimit.Limiter
+	down  []*ratelimit.Limiter
+	stats *transferStats
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for _, l := range c.down {
+		l.WaitN(n)
+	}
+	if c.stats != nil {
+		c.stats.read.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	for _, l := range c.up {
+		l.WaitN(len(b))
+	}
+	n, err := c.Conn.Write(b)
+	if c.stats != nil {
+		c.stats.written.Add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeteredConnStats(t *testing.T) {
+	clientConn, serverConn := createClientAndServer(t)
+	stats := &transferStats{}
+	c := client{conn: &meteredConn{Conn: clientConn, stats: stats}, stats: stats}
+
+	err := c.sendInterested()
+	require.Nil(t, err)
+
+	msgBytes := []byte{
+		0x00, 0x00, 0x00, 0x0c,
+		7,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0xaa, 0xbb, 0xcc,
+	}
+	_, err = serverConn.Write(msgBytes)
+	require.Nil(t, err)
+	_, err = c.read()
+	require.Nil(t, err)
+
+	expected := Stats{
+		PayloadDownloaded:  3,
+		PayloadUploaded:    0,
+		OverheadDownloaded: 13,
+		OverheadUploaded:   5,
+	}
+	assert.Equal(t, expected, stats.snapshot())
+}
+
+func TestMeteredConnThrottles(t *testing.T) {
+	clientConn, serverConn := createClientAndServer(t)
+	conn := &meteredConn{
+		Conn: clientConn,
+		up:   []*ratelimit.Limiter{nil, ratelimit.New(1000)},
+	}
+	go func() {
+		buf := make([]byte, 1000)
+		for {
+			if _, err := serverConn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := conn.Write(make([]byte, 100))
+		require.Nil(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond)
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+)
+
+// LevelTrace is below slog.LevelDebug and logs every message exchanged
+// with peers
+const LevelTrace = slog.LevelDebug - 4
+
+func (t *Torrent) logger() *slog.Logger {
+	l := t.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	return l.With(
+		slog.String("infohash", hex.EncodeToString(t.InfoHash[:])),
+		slog.String("torrent", t.Name),
+	)
+}
+
+// trace logs a message sent to or received from the peer
+func (c *client) trace(direction string, msg *message) {
+	if c.log == nil || !c.log.Enabled(context.Background(), LevelTrace) {
+		return
+	}
+	c.log.Log(context.Background(), LevelTrace, direction,
+		slog.String("type", msg.name()),
+		slog.Int("length", msgLength(msg)),
+	)
+}
+
+func msgLength(msg *message) int {
+	if msg == nil {
+		return 0
+	}
+	return len(msg.Payload)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	tests := map[string]struct {
+		level  slog.Level
+		output string
+	}{
+		"trace enabled": {
+			level:  LevelTrace,
+			output: "level=DEBUG-4 msg=sent type=Interested length=0\n",
+		},
+		"trace disabled": {
+			level:  slog.LevelDebug,
+			output: "",
+		},
+	}
+
+	for _, test := range tests {
+		clientConn, _ := createClientAndServer(t)
+		buf := &bytes.Buffer{}
+		handler := slog.NewTextHandler(buf, &slog.HandlerOptions{
+			Level: test.level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+		c := client{conn: clientConn, log: slog.New(handler)}
+
+		err := c.sendInterested()
+		require.Nil(t, err)
+		assert.Equal(t, test.output, buf.String())
+	}
+}
@@ -27,6 +27,12 @@ const (
 	msgPiece messageID = 7
 	// MsgCancel cancels a request
 	msgCancel messageID = 8
+	// MsgHashRequest asks for hashes of a v2 merkle tree (BEP 52)
+	msgHashRequest messageID = 21
+	// MsgHashes delivers the hashes of a hash request with their proof
+	msgHashes messageID = 22
+	// MsgHashReject refuses a hash request
+	msgHashReject messageID = 23
 )
 
 // Message stores ID and payload of a message
@@ -87,6 +93,68 @@ func parseHave(msg *message) (int, error) {
 	return index, nil
 }
 
+// hashRequest identifies hashes of the merkle tree of a file. It is the
+// payload of a hash request and a hash reject, and starts a hashes message.
+type hashRequest struct {
+	piecesRoot [32]byte
+	// baseLayer is the layer of the requested hashes, 0 being the leaves
+	baseLayer int
+	index     int
+	length    int
+	// proofLayers is the number of uncle hashes wanted above the
+	// requested ones
+	proofLayers int
+}
+
+// hashRequestLength is the size of a serialized hashRequest
+const hashRequestLength = 48
+
+func (r hashRequest) payload(extra int) []byte {
+	payload := make([]byte, hashRequestLength, hashRequestLength+extra)
+	copy(payload, r.piecesRoot[:])
+	binary.BigEndian.PutUint32(payload[32:36], uint32(r.baseLayer))
+	binary.BigEndian.PutUint32(payload[36:40], uint32(r.index))
+	binary.BigEndian.PutUint32(payload[40:44], uint32(r.length))
+	binary.BigEndian.PutUint32(payload[44:48], uint32(r.proofLayers))
+	return payload
+}
+
+func parseHashRequestPayload(payload []byte) hashRequest {
+	var r hashRequest
+	copy(r.piecesRoot[:], payload)
+	r.baseLayer = int(binary.BigEndian.Uint32(payload[32:36]))
+	r.index = int(binary.BigEndian.Uint32(payload[36:40]))
+	r.length = int(binary.BigEndian.Uint32(payload[40:44]))
+	r.proofLayers = int(binary.BigEndian.Uint32(payload[44:48]))
+	return r
+}
+
+// FormatHashReject creates a HASH REJECT message
+func formatHashReject(r hashRequest) *message {
+	return &message{ID: msgHashReject, Payload: r.payload(0)}
+}
+
+// FormatHashes creates a HASHES message answering r with hashes, the
+// requested ones followed by the proof
+func formatHashes(r hashRequest, hashes [][32]byte) *message {
+	payload := r.payload(32 * len(hashes))
+	for _, h := range hashes {
+		payload = append(payload, h[:]...)
+	}
+	return &message{ID: msgHashes, Payload: payload}
+}
+
+// ParseHashRequest parses a HASH REQUEST or HASH REJECT message
+func parseHashRequest(msg *message) (hashRequest, error) {
+	if msg.ID != msgHashRequest && msg.ID != msgHashReject {
+		return hashRequest{}, fmt.Errorf("expected HASH REQUEST or HASH REJECT, got ID %d", msg.ID)
+	}
+	if len(msg.Payload) != hashRequestLength {
+		return hashRequest{}, fmt.Errorf("expected payload length %d, got length %d", hashRequestLength, len(msg.Payload))
+	}
+	return parseHashRequestPayload(msg.Payload), nil
+}
+
 // Serialize serializes a message into a buffer of the form
 // <length prefix><message ID><payload>
 // Interprets `nil` as a keep-alive message
@@ -153,6 +221,12 @@ func (m *message) name() string {
 		return "Piece"
 	case msgCancel:
 		return "Cancel"
+	case msgHashRequest:
+		return "HashRequest"
+	case msgHashes:
+		return "Hashes"
+	case msgHashReject:
+		return "HashReject"
 	default:
 		return fmt.Sprintf("Unknown#%d", m.ID)
 	}
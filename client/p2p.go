@@ -2,12 +2,18 @@ package client
 
 import (
 	"bytes"
-	"crypto/sha1"
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"runtime"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/parkma99/go-bittorrent-client/ipfilter"
 	"github.com/parkma99/go-bittorrent-client/peers"
 )
 
@@ -26,11 +32,55 @@ type Torrent struct {
 	PieceLength int
 	Length      int
 	Name        string
+	Limits      RateLimits
+	Events      *Bus
+	Logger      *slog.Logger
+	// Dialer connects to peers, nil dials directly
+	Dialer Dialer
+	// Listening keeps Download running while no peer is connected, so
+	// that peers can still arrive through AddConn
+	Listening bool
+	// Blocklist holds addresses that are never connected to or accepted
+	Blocklist *ipfilter.Filter
+	// Bans collects peers caught sending corrupt data, nil gives the
+	// torrent a list of its own
+	Bans *BanList
+	// Sources fetch pieces alongside the peers, for example web seeds
+	Sources []PieceSource
+	// Priorities holds the priority of each piece. Pieces past its end
+	// are PriorityNormal.
+	Priorities []Priority
+	// FilesV2 makes this a BitTorrent v2 torrent whose pieces are checked
+	// with the merkle trees of its files. Length is then the length of the
+	// files laid out one after another, each starting at a piece boundary.
+	// PieceHashes may be left empty.
+	FilesV2 []FileV2
+	// InfoHashV2 is the truncated v2 info hash of a hybrid torrent, which
+	// has both PieceHashes and FilesV2. PeersV2 are the peers of its v2
+	// swarm, they are connected to with InfoHashV2.
+	InfoHashV2 [20]byte
+	PeersV2    []peers.Peer
+
+	stats   transferStats
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	run     *downloadRun
+	// pieces holds the verified pieces, the others are nil
+	pieces  [][]byte
+	done    bitfield
+	corrupt map[int]*corruptPiece
+	readers map[*Reader]struct{}
+	// verified is closed when the next piece is verified
+	verified chan struct{}
+	// ended is closed when the last call to Download returned runErr
+	ended  chan struct{}
+	runErr error
+	v2Once sync.Once
+	layout *layoutV2
 }
 
 type pieceWork struct {
 	index  int
-	hash   [20]byte
 	length int
 }
 
@@ -60,9 +110,9 @@ func (state *pieceProgress) readMessage() error {
 
 	switch msg.ID {
 	case msgUnchoke:
-		state.client.choked = false
+		state.client.choked.Store(false)
 	case msgChoke:
-		state.client.choked = true
+		state.client.choked.Store(true)
 	case msgHave:
 		index, err := parseHave(msg)
 		if err != nil {
@@ -76,6 +126,11 @@ func (state *pieceProgress) readMessage() error {
 		}
 		state.downloaded += n
 		state.backlog--
+	case msgHashRequest:
+		return state.client.answerHashRequest(msg)
+	case msgHashes, msgHashReject:
+		// Answers to requests we never send, the piece layers come
+		// from the metainfo
 	}
 	return nil
 }
@@ -94,7 +149,7 @@ func attemptDownloadPiece(c *client, pw *pieceWork) ([]byte, error) {
 
 	for state.downloaded < pw.length {
 		// If unchoked, send requests until we have enough unfulfilled requests
-		if !state.client.choked {
+		if !state.client.choked.Load() {
 			for state.backlog < MaxBacklog && state.requested < pw.length {
 				blockSize := MaxBlockSize
 				// Last block might be shorter than the typical block
@@ -120,54 +175,228 @@ func attemptDownloadPiece(c *client, pw *pieceWork) ([]byte, error) {
 	return state.buf, nil
 }
 
-func checkIntegrity(pw *pieceWork, buf []byte) error {
-	hash := sha1.Sum(buf)
-	if !bytes.Equal(hash[:], pw.hash[:]) {
-		return fmt.Errorf("index %d failed integrity check", pw.index)
+// downloadRun holds the queues shared by the workers of a running Download
+type downloadRun struct {
+	ctx     context.Context
+	picker  *picker
+	results chan *pieceResult
+	// exited is signalled whenever a worker stops, active counts the
+	// workers still running
+	exited chan struct{}
+	active atomic.Int32
+	// workers is waited on before Download returns
+	workers sync.WaitGroup
+}
+
+// spawn runs worker in a goroutine counted as an active worker of run
+func (run *downloadRun) spawn(worker func()) {
+	run.active.Add(1)
+	run.workers.Add(1)
+	go func() {
+		defer run.workers.Done()
+		worker()
+		run.active.Add(-1)
+		select {
+		case run.exited <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+func (t *Torrent) clientConfig(log *slog.Logger) clientConfig {
+	return clientConfig{
+		dialer:   t.Dialer,
+		peerID:   t.PeerID,
+		infoHash: t.InfoHash,
+		limits:   t.peerLimits(),
+		stats:    &t.stats,
+		log:      log,
+		v2:       t.v2(),
 	}
-	return nil
 }
 
-func (t *Torrent) startDownloadWorker(peer peers.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
-	c, err := newClient(peer, t.PeerID, t.InfoHash)
+// startWorker runs a download worker on the connection returned by connect
+func (t *Torrent) startWorker(run *downloadRun, peer peers.Peer, connect func(clientConfig) (*client, error)) {
+	run.spawn(func() { t.downloadWorker(run, peer, connect) })
+}
+
+func (t *Torrent) downloadWorker(run *downloadRun, peer peers.Peer, connect func(clientConfig) (*client, error)) {
+	ctx, picker, results := run.ctx, run.picker, run.results
+	log := t.logger().With(slog.String("peer", peer.String()))
+	c, err := connect(t.clientConfig(log))
 	if err != nil {
-		log.Printf("Could not handshake with %s. Disconnecting\n", peer.IP)
+		log.Debug("could not handshake with peer", slog.Any("error", err))
 		return
 	}
 	defer c.conn.Close()
-	log.Printf("Completed handshake with %s\n", peer.IP)
+	// Closing the connection unblocks any read or write in progress
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+	t.addClient(c)
+	defer t.removeClient(c)
+	remote := ParsePeerID(c.remoteID)
+	log = log.With(slog.String("client", remote.String()))
+	log.Debug("completed handshake")
+	t.Events.Publish(PeerConnected{Peer: peer, Client: remote})
+	var disconnectErr error
+	defer func() {
+		t.Events.Publish(PeerDisconnected{Peer: peer, Err: disconnectErr})
+	}()
 
 	c.sendUnchoke()
 	c.sendInterested()
 
-	for pw := range workQueue {
-		if !c.bitfield.hasPiece(pw.index) {
-			workQueue <- pw // Put piece back on the queue
-			continue
+	for {
+		pw, err := picker.next(ctx, c.bitfield.hasPiece)
+		if err != nil {
+			return
 		}
 
 		// Download the piece
 		buf, err := attemptDownloadPiece(c, pw)
 		if err != nil {
-			log.Println("Exiting", err)
-			workQueue <- pw // Put piece back on the queue
+			log.Debug("disconnecting from peer", slog.Int("piece", pw.index), slog.Any("error", err))
+			picker.add(pw.index) // Put piece back for other workers
+			if ctx.Err() == nil {
+				disconnectErr = err
+				t.Events.Publish(PieceFailed{Index: pw.index, Peer: peer, Err: err})
+			}
 			return
 		}
 
-		err = checkIntegrity(pw, buf)
+		err = t.checkIntegrity(pw.index, buf)
 		if err != nil {
-			log.Printf("Piece #%d failed integrity check\n", pw.index)
-			workQueue <- pw // Put piece back on the queue
+			log.Warn("piece failed integrity check", slog.Int("piece", pw.index))
+			// A peer failing the same piece twice is most likely the
+			// culprit, otherwise the blame waits for a good copy
+			if t.recordCorrupt(pw.index, buf, peer) {
+				t.punish(peer)
+			}
+			picker.add(pw.index) // Put piece back for other workers
+			t.Events.Publish(PieceFailed{Index: pw.index, Peer: peer, Err: err})
+			if banErr := t.refused(peer.IP); banErr != nil {
+				disconnectErr = banErr
+				return
+			}
 			continue
 		}
 
+		for _, culprit := range t.blameCorrupt(pw.index, buf) {
+			t.punish(culprit)
+		}
+		log.Debug("piece verified", slog.Int("piece", pw.index))
 		c.sendHave(pw.index)
-		results <- &pieceResult{pw.index, buf}
+		t.Events.Publish(PieceVerified{Index: pw.index, Peer: peer})
+		select {
+		case results <- &pieceResult{pw.index, buf}:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// AddConn hands a connection opened by a remote peer to the running
+// Download. The peer's handshake, carrying infoHash and peerID, must
+// already have been read with ReadIncomingHandshake. The connection is
+// closed if no Download is running, infoHash is not one of the torrent's
+// or the peer is blocked or banned.
+func (t *Torrent) AddConn(conn net.Conn, infoHash, peerID [20]byte) error {
+	if infoHash != t.InfoHash && (t.InfoHashV2 == [20]byte{} || infoHash != t.InfoHashV2) {
+		conn.Close()
+		return fmt.Errorf("peer asked for info hash %x", infoHash)
+	}
+	var peer peers.Peer
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		peer = peers.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	}
+	if err := t.refused(peer.IP); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The worker is started under the lock so that a Download returning
+	// concurrently waits for it
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run := t.run
+	if run == nil || run.ctx.Err() != nil {
+		conn.Close()
+		return errors.New("torrent is not downloading")
+	}
+	t.startWorker(run, peer, func(cfg clientConfig) (*client, error) {
+		cfg.infoHash = infoHash
+		return acceptClient(conn, peer, peerID, cfg)
+	})
+	return nil
+}
+
+func (t *Torrent) addClient(c *client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[*client]struct{})
+	}
+	t.clients[c] = struct{}{}
+}
+
+func (t *Torrent) removeClient(c *client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.clients, c)
+}
+
+func (t *Torrent) peerLimits() RateLimits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Limits
+}
+
+// SetPeerRateLimits changes the per-connection upload and download rates,
+// in bytes per second, of connected and future peers
+func (t *Torrent) SetPeerRateLimits(upload, download int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Limits.PeerUpload = upload
+	t.Limits.PeerDownload = download
+	for c := range t.clients {
+		c.upLimit.SetLimit(upload)
+		c.downLimit.SetLimit(download)
+	}
+}
+
+func (t *Torrent) numClients() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients)
+}
+
+// PeerStates returns how many connected peers are choking and unchoking us
+func (t *Torrent) PeerStates() (choked, unchoked int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.clients {
+		if c.choked.Load() {
+			choked++
+		} else {
+			unchoked++
+		}
+	}
+	return choked, unchoked
+}
+
+// Stats returns the number of bytes exchanged with peers so far
+func (t *Torrent) Stats() Stats {
+	return t.stats.snapshot()
+}
+
+// calculateBoundsForPiece returns where piece index lies in the torrent.
+// The last piece of a file of a v2 torrent ends with the file, unless
+// the torrent is hybrid and pads its files to piece boundaries.
 func (t *Torrent) calculateBoundsForPiece(index int) (begin int, end int) {
 	begin = index * t.PieceLength
+	if l := t.v2(); l != nil && len(t.PieceHashes) == 0 {
+		return begin, begin + l.pieces[index].length
+	}
 	end = begin + t.PieceLength
 	if end > t.Length {
 		end = t.Length
@@ -180,36 +409,247 @@ func (t *Torrent) calculatePieceSize(index int) int {
 	return end - begin
 }
 
-// Download downloads the torrent. This stores the entire file in memory.
-func (t *Torrent) Download() ([]byte, error) {
-	log.Println("Starting download for", t.Name)
+// AddPiece stores piece index obtained elsewhere, for example from an
+// earlier download, so that Download does not fetch it again. The data
+// must pass the integrity check.
+func (t *Torrent) AddPiece(index int, data []byte) error {
+	if index < 0 || index >= t.numPieces() {
+		return fmt.Errorf("piece index %d out of range", index)
+	}
+	if len(data) != t.calculatePieceSize(index) {
+		return fmt.Errorf("piece %d has %d bytes, expected %d", index, len(data), t.calculatePieceSize(index))
+	}
+	if err := t.checkIntegrity(index, data); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.initPieces()
+	t.pieces[index] = bytes.Clone(data)
+	t.setDone(index)
+	return nil
+}
+
+func (t *Torrent) initPieces() {
+	if t.pieces == nil {
+		t.pieces = make([][]byte, t.numPieces())
+		t.done = make(bitfield, (t.numPieces()+7)/8)
+	}
+}
+
+// ReadAt reads the torrent at off from the verified pieces, as if its
+// files were one after the other. Pieces that are not verified, such as
+// those with PrioritySkip, read as zeros.
+func (t *Torrent) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(t.Length) {
+		return 0, io.EOF
+	}
+	var err error
+	if int64(len(b)) > int64(t.Length)-off {
+		b = b[:int64(t.Length)-off]
+		err = io.EOF
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pos := int(off)
+	for n := 0; n < len(b); {
+		n += t.copyPiece(b[n:], pos+n)
+	}
+	return len(b), err
+}
+
+// copyPiece copies from pos up to the end of the piece it is in, and
+// returns the number of bytes copied. The padding that follows the files
+// of a v2 torrent and pieces that are not verified are zeros.
+func (t *Torrent) copyPiece(b []byte, pos int) int {
+	index := pos / t.PieceLength
+	end := min((index+1)*t.PieceLength, t.Length)
+	b = b[:min(len(b), end-pos)]
+	n := 0
+	if index < len(t.pieces) && pos-index*t.PieceLength < len(t.pieces[index]) {
+		n = copy(b, t.pieces[index][pos-index*t.PieceLength:])
+	}
+	clear(b[n:])
+	return len(b)
+}
+
+// Download downloads the torrent. Verified pieces are kept in memory, one
+// buffer each, and can be read back with ReadAt. Pieces with PrioritySkip
+// are left out and take no memory.
+// Pieces are fetched in order, except that those near the read position
+// of a Reader come first.
+// It returns early with ctx.Err() when ctx is done, and with a
+// *NoPeersError when every peer has disconnected. Connections to peers
+// are closed before Download returns. Pieces downloaded by an earlier
+// call are kept, so calling Download again resumes the download. Readers
+// waiting for a piece fail once Download returns without it.
+func (t *Torrent) Download(ctx context.Context) (err error) {
+	log := t.logger()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.mu.Lock()
+	if t.run != nil {
+		t.mu.Unlock()
+		return errors.New("download is already running")
+	}
+	t.initPieces()
 	// Init queues for workers to retrieve work and send results
-	workQueue := make(chan *pieceWork, len(t.PieceHashes))
-	results := make(chan *pieceResult)
-	for index, hash := range t.PieceHashes {
+	run := &downloadRun{
+		ctx:     ctx,
+		picker:  newPicker(t),
+		results: make(chan *pieceResult),
+		exited:  make(chan struct{}, 1),
+	}
+	t.run = run
+	ended := make(chan struct{})
+	t.ended, t.runErr = ended, nil
+	// Readers that gave up on an earlier run wait for this one
+	t.wakeReaders()
+	t.mu.Unlock()
+	defer func() {
+		cancel()
+		t.mu.Lock()
+		t.run = nil
+		t.runErr = err
+		close(ended)
+		t.mu.Unlock()
+		run.workers.Wait()
+	}()
+
+	donePieces := 0
+	doneBytes := 0
+	wanted := t.wantedPieces()
+	wantedBytes := 0
+	for _, index := range wanted {
 		length := t.calculatePieceSize(index)
-		workQueue <- &pieceWork{index, hash, length}
+		wantedBytes += length
+		if t.done.hasPiece(index) {
+			donePieces++
+			doneBytes += length
+			continue
+		}
+		run.picker.add(index)
 	}
+	log.Info("starting download",
+		slog.Int("peers", len(t.Peers)+len(t.PeersV2)),
+		slog.Int("sources", len(t.Sources)),
+		slog.Int("pieces", len(wanted)),
+		slog.Int("done", donePieces))
 
-	// Start workers
-	for _, peer := range t.Peers {
-		go t.startDownloadWorker(peer, workQueue, results)
+	// Start workers. A peer in both swarms of a hybrid torrent is only
+	// connected to once.
+	seen := make(map[string]bool)
+	for i, peer := range append(slices.Clip(t.Peers), t.PeersV2...) {
+		peer := peer
+		infoHash := t.InfoHash
+		if i >= len(t.Peers) {
+			infoHash = t.InfoHashV2
+		}
+		if seen[peer.String()] {
+			continue
+		}
+		seen[peer.String()] = true
+		if err := t.refused(peer.IP); err != nil {
+			log.Debug("skipping peer", slog.String("peer", peer.String()), slog.Any("error", err))
+			continue
+		}
+		t.startWorker(run, peer, func(cfg clientConfig) (*client, error) {
+			cfg.infoHash = infoHash
+			return newClient(ctx, peer, cfg)
+		})
 	}
+	for _, source := range t.Sources {
+		t.startSource(run, source)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	rate := newRateMeter(t.stats.payloadRead.Load())
 
 	// Collect results into a buffer until full
-	buf := make([]byte, t.Length)
-	donePieces := 0
-	for donePieces < len(t.PieceHashes) {
-		res := <-results
-		begin, end := t.calculateBoundsForPiece(res.index)
-		copy(buf[begin:end], res.buf)
+	for donePieces < len(wanted) {
+		if run.active.Load() == 0 && !t.Listening {
+			log.Warn("no peers left", slog.Int("done", donePieces))
+			return &NoPeersError{Done: donePieces, Total: len(wanted)}
+		}
+
+		var res *pieceResult
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-run.exited:
+			continue
+		case <-ticker.C:
+			rate.update(t.stats.payloadRead.Load())
+			t.publishProgress(donePieces, len(wanted), wantedBytes-doneBytes, rate)
+			continue
+		case res = <-run.results:
+		}
+		t.mu.Lock()
+		t.pieces[res.index] = res.buf
+		t.setDone(res.index)
+		t.mu.Unlock()
 		donePieces++
+		doneBytes += len(res.buf)
+		t.publishProgress(donePieces, len(wanted), wantedBytes-doneBytes, rate)
+	}
+	log.Info("download complete")
+
+	return nil
+}
 
-		percent := float64(donePieces) / float64(len(t.PieceHashes)) * 100
-		numWorkers := runtime.NumGoroutine() - 1 // subtract 1 for main thread
-		log.Printf("(%0.2f%%) Downloaded piece #%d from %d peers\n", percent, res.index, numWorkers)
+func (t *Torrent) publishProgress(donePieces, totalPieces, left int, rate *rateMeter) {
+	p := Progress{
+		DonePieces:     donePieces,
+		TotalPieces:    totalPieces,
+		Peers:          t.numClients(),
+		BytesPerSecond: rate.bytesPerSecond,
+	}
+	if rate.bytesPerSecond > 0 {
+		p.ETA = time.Duration(float64(left) / rate.bytesPerSecond * float64(time.Second))
 	}
-	close(workQueue)
+	t.Events.Publish(p)
+}
+
+// rateMeter estimates a transfer rate from a growing byte counter
+type rateMeter struct {
+	last           int64
+	lastTime       time.Time
+	bytesPerSecond float64
+}
+
+func newRateMeter(start int64) *rateMeter {
+	return &rateMeter{last: start, lastTime: time.Now()}
+}
+
+func (m *rateMeter) update(total int64) {
+	now := time.Now()
+	elapsed := now.Sub(m.lastTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(total-m.last) / elapsed
+	// Smooth the rate so that the ETA does not jump around with every tick
+	if m.bytesPerSecond == 0 {
+		m.bytesPerSecond = current
+	} else {
+		m.bytesPerSecond = 0.7*m.bytesPerSecond + 0.3*current
+	}
+	m.last = total
+	m.lastTime = now
+}
+
+// NoPeersError is returned by Download when every peer has disconnected
+// before all pieces were downloaded
+type NoPeersError struct {
+	Done  int
+	Total int
+}
 
-	return buf, nil
+func (e *NoPeersError) Error() string {
+	return fmt.Sprintf("no peers left after downloading %d of %d pieces", e.Done, e.Total)
 }
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/ipfilter"
+	"github.com/parkma99/go-bittorrent-client/peers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSeeder accepts connections and serves data to anyone who asks for
+// infoHash. Corrupt makes it flip a byte in every block it sends.
+type fakeSeeder struct {
+	ln          net.Listener
+	infoHash    [20]byte
+	data        []byte
+	pieceLength int
+	corrupt     bool
+}
+
+func newFakeSeeder(t *testing.T, infoHash [20]byte, data []byte, pieceLength int, corrupt bool) *fakeSeeder {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := &fakeSeeder{ln: ln, infoHash: infoHash, data: data, pieceLength: pieceLength, corrupt: corrupt}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSeeder) peer() peers.Peer {
+	host, portStr, _ := net.SplitHostPort(s.ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return peers.Peer{IP: net.ParseIP(host), Port: uint16(port)}
+}
+
+func (s *fakeSeeder) serve(conn net.Conn) {
+	defer conn.Close()
+	h, err := readHandshake(conn)
+	if err != nil || h.InfoHash != s.infoHash {
+		return
+	}
+	var peerID [20]byte
+	copy(peerID[:], "-FS0001-000000000000")
+	conn.Write(newHandshake(s.infoHash, peerID).serialize())
+
+	numPieces := (len(s.data) + s.pieceLength - 1) / s.pieceLength
+	bf := make(bitfield, (numPieces+7)/8)
+	for i := 0; i < numPieces; i++ {
+		bf.setPiece(i)
+	}
+	conn.Write((&message{ID: msgBitfield, Payload: bf}).serialize())
+	conn.Write((&message{ID: msgUnchoke}).serialize())
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg == nil || msg.ID != msgRequest {
+			continue
+		}
+		index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+		begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+		length := int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+		start := index*s.pieceLength + begin
+		payload := make([]byte, 8+length)
+		copy(payload[0:8], msg.Payload[0:8])
+		copy(payload[8:], s.data[start:start+length])
+		if s.corrupt {
+			payload[8] ^= 0xff
+		}
+		if _, err := conn.Write((&message{ID: msgPiece, Payload: payload}).serialize()); err != nil {
+			return
+		}
+	}
+}
+
+func newTestTorrent(data []byte, pieceLength int) *Torrent {
+	t := &Torrent{
+		PeerID:      [20]byte{1, 2, 3},
+		InfoHash:    sha1.Sum(data),
+		PieceLength: pieceLength,
+		Length:      len(data),
+		Name:        "test",
+	}
+	for begin := 0; begin < len(data); begin += pieceLength {
+		end := begin + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		t.PieceHashes = append(t.PieceHashes, sha1.Sum(data[begin:end]))
+	}
+	return t
+}
+
+// readAll returns the whole torrent as read from its verified pieces
+func readAll(t *testing.T, tor *Torrent) []byte {
+	t.Helper()
+	buf := make([]byte, tor.Length)
+	_, err := tor.ReadAt(buf, 0)
+	require.Nil(t, err)
+	return buf
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestDownload(t *testing.T) {
+	data := testData(100000)
+	tor := newTestTorrent(data, 32768)
+	seeder := newFakeSeeder(t, tor.InfoHash, data, tor.PieceLength, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+
+	require.Nil(t, tor.Download(context.Background()))
+	assert.Equal(t, data, readAll(t, tor))
+	assert.Equal(t, int64(len(data)), tor.Stats().PayloadDownloaded)
+}
+
+func TestDownloadSkippedPieces(t *testing.T) {
+	data := testData(4 * 512)
+	tor := newTestTorrent(data, 512)
+	tor.Priorities = []Priority{PriorityNormal, PrioritySkip, PrioritySkip, PriorityNormal}
+	seeder := newFakeSeeder(t, tor.InfoHash, data, tor.PieceLength, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+
+	require.Nil(t, tor.Download(context.Background()))
+	assert.Nil(t, tor.pieces[1])
+	assert.Nil(t, tor.pieces[2])
+	want := append(append([]byte{}, data[:512]...), make([]byte, 2*512)...)
+	want = append(want, data[3*512:]...)
+	assert.Equal(t, want, readAll(t, tor))
+}
+
+func TestDownloadNoPeers(t *testing.T) {
+	data := testData(1000)
+	tor := newTestTorrent(data, 512)
+
+	// Nobody listens on a closed listener's port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+	tor.Peers = []peers.Peer{{IP: addr.IP, Port: uint16(addr.Port)}}
+
+	err = tor.Download(context.Background())
+	var noPeers *NoPeersError
+	require.True(t, errors.As(err, &noPeers))
+	assert.Equal(t, 0, noPeers.Done)
+	assert.Equal(t, 2, noPeers.Total)
+}
+
+func TestDownloadBlocklist(t *testing.T) {
+	data := testData(1000)
+	tor := newTestTorrent(data, 512)
+	seeder := newFakeSeeder(t, tor.InfoHash, data, 512, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+	tor.Blocklist = ipfilter.New()
+	require.Nil(t, tor.Blocklist.AddCIDR("127.0.0.0/8"))
+
+	err := tor.Download(context.Background())
+	var noPeers *NoPeersError
+	require.True(t, errors.As(err, &noPeers))
+
+	tor.Listening = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tor.Download(ctx)
+	require.Eventually(t, func() bool {
+		tor.mu.Lock()
+		defer tor.mu.Unlock()
+		return tor.run != nil
+	}, time.Second, 10*time.Millisecond)
+	conn, _ := net.Pipe()
+	assert.NotNil(t, tor.AddConn(blockedAddrConn{conn}, tor.InfoHash, [20]byte{}))
+}
+
+type blockedAddrConn struct {
+	net.Conn
+}
+
+func (blockedAddrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	data := testData(1000)
+	tor := newTestTorrent(data, 512)
+	// The seeder never sends valid data and gets banned, while listening
+	// the download can only end through the context
+	seeder := newFakeSeeder(t, tor.InfoHash, data, tor.PieceLength, true)
+	tor.Peers = []peers.Peer{seeder.peer()}
+	tor.Listening = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := tor.Download(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestDownloadClosesConnections(t *testing.T) {
+	data := testData(1000)
+	tor := newTestTorrent(data, 512)
+	// The peer completes the handshake and then stays silent
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := readHandshake(conn); err != nil {
+			return
+		}
+		conn.Write(newHandshake(tor.InfoHash, [20]byte{9}).serialize())
+		for {
+			if _, err := readMessage(conn); err != nil {
+				return
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	tor.Peers = []peers.Peer{{IP: addr.IP, Port: uint16(addr.Port)}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err = tor.Download(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, tor.numClients())
+}
+
+func TestDownloadEvents(t *testing.T) {
+	data := testData(100000)
+	tor := newTestTorrent(data, 32768)
+	seeder := newFakeSeeder(t, tor.InfoHash, data, tor.PieceLength, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+	tor.Events = &Bus{}
+
+	var connected, verified int
+	var last Progress
+	tor.Events.Subscribe(func(e Event) {
+		switch e := e.(type) {
+		case PeerConnected:
+			connected++
+		case PieceVerified:
+			verified++
+		case Progress:
+			last = e
+		}
+	})
+
+	err := tor.Download(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 1, connected)
+	assert.Equal(t, 4, verified)
+	assert.Equal(t, 4, last.DonePieces)
+	assert.Equal(t, 4, last.TotalPieces)
+	assert.Equal(t, 1, last.Peers)
+}
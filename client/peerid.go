@@ -0,0 +1,144 @@
+package client
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// DefaultPeerIDPrefix identifies this client in Azureus style: a dash, two
+// letters naming the client, four version digits and another dash
+const DefaultPeerIDPrefix = "-GB0001-"
+
+// NewPeerID returns a peer ID starting with prefix and filled up with
+// random bytes. An empty prefix means DefaultPeerIDPrefix.
+func NewPeerID(prefix string) ([20]byte, error) {
+	var id [20]byte
+	if prefix == "" {
+		prefix = DefaultPeerIDPrefix
+	}
+	if len(prefix) > len(id)-8 {
+		return id, fmt.Errorf("peer ID prefix %q is longer than %d bytes", prefix, len(id)-8)
+	}
+	n := copy(id[:], prefix)
+	// Stick to printable characters, some trackers mangle anything else
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	random := make([]byte, len(id)-n)
+	if _, err := rand.Read(random); err != nil {
+		return id, err
+	}
+	for i, b := range random {
+		id[n+i] = alphabet[int(b)%len(alphabet)]
+	}
+	return id, nil
+}
+
+// ClientInfo is the name and version of a peer's client, as far as it can
+// be told from its peer ID
+type ClientInfo struct {
+	Name    string
+	Version string
+}
+
+func (ci ClientInfo) String() string {
+	if ci.Version == "" {
+		return ci.Name
+	}
+	return ci.Name + " " + ci.Version
+}
+
+var azureusClients = map[string]string{
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"GB": "go-bittorrent-client",
+	"KT": "KTorrent",
+	"LT": "libtorrent (Rasterbar)",
+	"lt": "libTorrent (rakshasa)",
+	"qB": "qBittorrent",
+	"TR": "Transmission",
+	"UT": "µTorrent",
+	"UM": "µTorrent Mac",
+	"WW": "WebTorrent",
+}
+
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+// ParsePeerID tells the client name and version from a peer ID in the
+// Azureus (-qB4250-...), Shadow (T03I-----...) or Mainline (M7-4-3--...)
+// style. Unknown IDs yield an empty ClientInfo.
+func ParsePeerID(id [20]byte) ClientInfo {
+	switch {
+	case id[0] == '-' && id[7] == '-':
+		return parseAzureus(id)
+	case id[0] == 'M' && isDigit(id[1]):
+		return parseMainline(id)
+	case shadowClients[id[0]] != "" && id[4] == '-' && id[5] == '-':
+		return parseShadow(id)
+	}
+	return ClientInfo{}
+}
+
+func parseAzureus(id [20]byte) ClientInfo {
+	code := string(id[1:3])
+	name, ok := azureusClients[code]
+	if !ok {
+		name = "unknown (" + code + ")"
+	}
+	// Versions are written one character per component, 0-9 and A-Z
+	var parts []string
+	for _, b := range id[3:7] {
+		parts = append(parts, fmt.Sprint(versionDigit(b)))
+	}
+	// Trailing zero components are usually left out, keep at least two
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return ClientInfo{Name: name, Version: strings.Join(parts, ".")}
+}
+
+func parseMainline(id [20]byte) ClientInfo {
+	// M<major>-<minor>-<patch>-- where each number may have several digits
+	fields := strings.SplitN(strings.TrimRight(string(id[1:]), "\x00"), "-", 4)
+	if len(fields) < 3 {
+		return ClientInfo{Name: "BitTorrent"}
+	}
+	return ClientInfo{Name: "BitTorrent", Version: strings.Join(fields[:3], ".")}
+}
+
+func parseShadow(id [20]byte) ClientInfo {
+	var parts []string
+	for _, b := range id[1:4] {
+		if b == '-' {
+			break
+		}
+		parts = append(parts, fmt.Sprint(versionDigit(b)))
+	}
+	return ClientInfo{Name: shadowClients[id[0]], Version: strings.Join(parts, ".")}
+}
+
+func versionDigit(b byte) int {
+	switch {
+	case isDigit(b):
+		return int(b - '0')
+	case b >= 'A' && b <= 'Z':
+		return int(b-'A') + 10
+	case b >= 'a' && b <= 'z':
+		return int(b-'a') + 36
+	}
+	return 0
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
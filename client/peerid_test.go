@@ -0,0 +1,67 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPeerID(t *testing.T) {
+	a, err := NewPeerID("")
+	assert.Nil(t, err)
+	b, err := NewPeerID("")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(a[:]), DefaultPeerIDPrefix))
+	assert.NotEqual(t, a, b)
+
+	c, err := NewPeerID("-XX1234-")
+	assert.Nil(t, err)
+	assert.Equal(t, "-XX1234-", string(c[:8]))
+
+	_, err = NewPeerID("this prefix is far too long")
+	assert.NotNil(t, err)
+}
+
+func TestParsePeerID(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		output ClientInfo
+	}{
+		"qBittorrent": {
+			input:  "-qB4250-abcdefghijkl",
+			output: ClientInfo{Name: "qBittorrent", Version: "4.2.5"},
+		},
+		"Transmission": {
+			input:  "-TR3000-abcdefghijkl",
+			output: ClientInfo{Name: "Transmission", Version: "3.0"},
+		},
+		"this client": {
+			input:  DefaultPeerIDPrefix + "abcdefghijkl",
+			output: ClientInfo{Name: "go-bittorrent-client", Version: "0.0.0.1"},
+		},
+		"unknown azureus client": {
+			input:  "-ZZ1A00-abcdefghijkl",
+			output: ClientInfo{Name: "unknown (ZZ)", Version: "1.10"},
+		},
+		"mainline": {
+			input:  "M7-10-3--abcdefghijk",
+			output: ClientInfo{Name: "BitTorrent", Version: "7.10.3"},
+		},
+		"shadow": {
+			input:  "T03I--00abcdefghijkl",
+			output: ClientInfo{Name: "BitTornado", Version: "0.3.18"},
+		},
+		"random bytes": {
+			input:  "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14",
+			output: ClientInfo{},
+		},
+	}
+
+	for name, test := range tests {
+		var id [20]byte
+		copy(id[:], test.input)
+		assert.Equal(t, test.output, ParsePeerID(id), name)
+	}
+	assert.Equal(t, "qBittorrent 4.2.5", ClientInfo{Name: "qBittorrent", Version: "4.2.5"}.String())
+}
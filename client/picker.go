@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// picker hands out the pieces that still have to be downloaded. Pieces in
+// the readahead window of a Reader come first, nearest to the read
+// position first. All other pieces go by priority and, within a priority,
+// by index, so that a download without readers is sequential.
+type picker struct {
+	t *Torrent
+
+	mu      sync.Mutex
+	pending []bool
+	// count holds the number of pending pieces per priority
+	count map[Priority]int
+	// low is at or below the lowest pending index
+	low int
+	// changed is closed and replaced whenever a piece is put back
+	changed chan struct{}
+}
+
+func newPicker(t *Torrent) *picker {
+	return &picker{
+		t:       t,
+		pending: make([]bool, t.numPieces()),
+		count:   make(map[Priority]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// add makes piece index available to workers, again if it was handed
+// out before
+func (p *picker) add(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[index] {
+		return
+	}
+	p.pending[index] = true
+	p.count[p.t.piecePriority(index)]++
+	if index < p.low {
+		p.low = index
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// next waits for a pending piece that has reports the peer to have
+func (p *picker) next(ctx context.Context, has func(index int) bool) (*pieceWork, error) {
+	for {
+		p.mu.Lock()
+		index := p.choose(has)
+		if index >= 0 {
+			p.pending[index] = false
+			p.count[p.t.piecePriority(index)]--
+			p.mu.Unlock()
+			return &pieceWork{index, p.t.calculatePieceSize(index)}, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// choose returns the best pending piece the peer has, or -1
+func (p *picker) choose(has func(index int) bool) int {
+	for _, w := range p.t.readaheadWindows() {
+		for index := w.first; index <= w.last; index++ {
+			if p.pending[index] && has(index) {
+				return index
+			}
+		}
+	}
+
+	for p.low < len(p.pending) && !p.pending[p.low] {
+		p.low++
+	}
+	for prio := PriorityHigh; prio > PrioritySkip; prio-- {
+		if p.count[prio] == 0 {
+			continue
+		}
+		for index := p.low; index < len(p.pending); index++ {
+			if p.pending[index] && p.t.piecePriority(index) == prio && has(index) {
+				return index
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,58 @@
+package client
+
+import "fmt"
+
+// Priority decides whether and how early a piece is downloaded. The zero
+// value is PriorityNormal.
+type Priority int
+
+const (
+	// PrioritySkip pieces are not downloaded at all
+	PrioritySkip Priority = iota - 2
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "skip"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// ParsePriority is the inverse of Priority.String
+func ParsePriority(s string) (Priority, error) {
+	for p := PrioritySkip; p <= PriorityHigh; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+func (t *Torrent) piecePriority(index int) Priority {
+	if index < len(t.Priorities) {
+		return t.Priorities[index]
+	}
+	return PriorityNormal
+}
+
+// wantedPieces returns the pieces that are not skipped
+func (t *Torrent) wantedPieces() []int {
+	var wanted []int
+	for index := 0; index < t.numPieces(); index++ {
+		if t.piecePriority(index) != PrioritySkip {
+			wanted = append(wanted, index)
+		}
+	}
+	return wanted
+}
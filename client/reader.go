@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultReadahead is how many bytes past its read position a Reader asks
+// to be downloaded first
+const DefaultReadahead = 4 << 20
+
+// Reader reads a section of a torrent while it is downloading. Reads wait
+// until the pieces they need are verified, and the pieces around the read
+// position are downloaded before all others. A Reader is not safe for
+// concurrent use, but several Readers may read the same torrent.
+type Reader struct {
+	t    *Torrent
+	ctx  context.Context
+	off  int64
+	size int64
+
+	pos int64
+	// want and readahead are read by the picker under t.mu
+	want      int64
+	readahead int64
+}
+
+// pieceWindow is a range of pieces a Reader wants next, last included
+type pieceWindow struct {
+	first, last int
+}
+
+// NewReader returns a Reader over the whole torrent. Reads fail with
+// ctx.Err() once ctx is done. The Reader must be closed after use.
+func (t *Torrent) NewReader(ctx context.Context) *Reader {
+	return t.NewSectionReader(ctx, 0, int64(t.Length))
+}
+
+// NewSectionReader is NewReader for the n bytes starting at off, such as
+// one file of the torrent
+func (t *Torrent) NewSectionReader(ctx context.Context, off, n int64) *Reader {
+	r := &Reader{t: t, ctx: ctx, off: off, size: n, readahead: DefaultReadahead}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.readers == nil {
+		t.readers = make(map[*Reader]struct{})
+	}
+	t.readers[r] = struct{}{}
+	return r
+}
+
+// SetReadahead changes how many bytes past the read position are
+// downloaded first
+func (r *Reader) SetReadahead(n int64) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	r.readahead = n
+}
+
+// Close stops the Reader from influencing which pieces are downloaded
+func (r *Reader) Close() error {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	delete(r.t.readers, r)
+	return nil
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > r.size-r.pos {
+		b = b[:r.size-r.pos]
+	}
+	n, err := r.readSome(b, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes at off, waiting for every piece they span
+func (r *Reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	read := 0
+	for read < len(b) {
+		if off+int64(read) >= r.size {
+			return read, io.EOF
+		}
+		end := len(b)
+		if int64(end-read) > r.size-off-int64(read) {
+			end = read + int(r.size-off-int64(read))
+		}
+		n, err := r.readSome(b[read:end], off+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// readSome waits for the piece at off and reads from it and the verified
+// pieces following it, at most len(b) bytes
+func (r *Reader) readSome(b []byte, off int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	t := r.t
+	pos := int(r.off + off)
+	for {
+		t.mu.Lock()
+		r.want = off
+		index := pos / t.PieceLength
+		if t.done != nil && t.done.hasPiece(index) {
+			n := 0
+			for n < len(b) && t.done.hasPiece(index) {
+				n += t.copyPiece(b[n:], pos+n)
+				index++
+			}
+			t.mu.Unlock()
+			return n, nil
+		}
+		verified, ended := t.pieceVerified(), t.ended
+		t.mu.Unlock()
+
+		if t.piecePriority(index) == PrioritySkip {
+			return 0, fmt.Errorf("piece %d is skipped", index)
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-verified:
+		case <-ended:
+			if err := t.endedWithout(ended, index); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// endedWithout returns why the download that closed ended stopped before
+// piece index was verified, or nil when the piece arrived or another
+// download started meanwhile
+func (t *Torrent) endedWithout(ended chan struct{}, index int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended != ended || t.done.hasPiece(index) {
+		return nil
+	}
+	if t.runErr != nil {
+		return t.runErr
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// pieceVerified returns a channel that is closed when the next piece is
+// verified. t.mu must be held.
+func (t *Torrent) pieceVerified() <-chan struct{} {
+	if t.verified == nil {
+		t.verified = make(chan struct{})
+	}
+	return t.verified
+}
+
+// setDone marks piece index as verified and wakes up waiting Readers.
+// t.mu must be held.
+func (t *Torrent) setDone(index int) {
+	t.done.setPiece(index)
+	t.wakeReaders()
+}
+
+// wakeReaders makes waiting Readers check their piece again. t.mu must be
+// held.
+func (t *Torrent) wakeReaders() {
+	if t.verified != nil {
+		close(t.verified)
+		t.verified = nil
+	}
+}
+
+// readaheadWindows returns the pieces each Reader wants next
+func (t *Torrent) readaheadWindows() []pieceWindow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var windows []pieceWindow
+	for r := range t.readers {
+		start := r.off + r.want
+		end := start + r.readahead
+		if end > r.off+r.size {
+			end = r.off + r.size
+		}
+		if end <= start {
+			end = start + 1
+		}
+		if start >= r.off+r.size {
+			continue
+		}
+		windows = append(windows, pieceWindow{
+			first: int(start) / t.PieceLength,
+			last:  int(end-1) / t.PieceLength,
+		})
+	}
+	return windows
+}
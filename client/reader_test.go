@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/peers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickerOrder(t *testing.T) {
+	tor := newTestTorrent(testData(1000), 100)
+	tor.Priorities = []Priority{PriorityLow, PriorityNormal, PrioritySkip, PriorityNormal, PriorityHigh}
+	p := newPicker(tor)
+	for _, index := range tor.wantedPieces() {
+		p.add(index)
+	}
+	hasAll := func(int) bool { return true }
+	next := func() int {
+		pw, err := p.next(context.Background(), hasAll)
+		require.Nil(t, err)
+		return pw.index
+	}
+
+	// A reader at byte 750 wants pieces 7 and 8 before everything else
+	r := tor.NewReader(context.Background())
+	r.SetReadahead(150)
+	r.want = 750
+	assert.Equal(t, 7, next())
+	assert.Equal(t, 8, next())
+	r.Close()
+
+	order := []int{4, 1, 3, 5, 6, 9, 0}
+	for _, index := range order {
+		assert.Equal(t, index, next())
+	}
+
+	// Only pieces the peer has are handed out, the rest waits
+	p.add(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := p.next(ctx, func(index int) bool { return index != 3 })
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestReader(t *testing.T) {
+	data := testData(100000)
+	tor := newTestTorrent(data, 16384)
+	seeder := newFakeSeeder(t, tor.InfoHash, data, tor.PieceLength, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	section := tor.NewSectionReader(ctx, 20000, 50000)
+	defer section.Close()
+	whole := tor.NewReader(ctx)
+	defer whole.Close()
+	go tor.Download(ctx)
+
+	// Reading starts before the download is complete and waits for pieces
+	buf := make([]byte, 100)
+	n, err := section.ReadAt(buf, 40000)
+	require.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[60000:60100], buf)
+
+	pos, err := section.Seek(-10, io.SeekEnd)
+	require.Nil(t, err)
+	assert.Equal(t, int64(49990), pos)
+	rest, err := io.ReadAll(section)
+	require.Nil(t, err)
+	assert.Equal(t, data[69990:70000], rest)
+
+	all, err := io.ReadAll(whole)
+	require.Nil(t, err)
+	assert.Equal(t, data, all)
+}
+
+func TestReaderCancel(t *testing.T) {
+	tor := newTestTorrent(testData(1000), 512)
+	tor.Listening = true
+	ctx, cancel := context.WithCancel(context.Background())
+	go tor.Download(ctx)
+
+	r := tor.NewReader(ctx)
+	defer r.Close()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := r.Read(make([]byte, 10))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReaderDownloadEnds(t *testing.T) {
+	tor := newTestTorrent(testData(1000), 512)
+	tor.Listening = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tor.Download(ctx) }()
+
+	// The reader outlives the download it waits for
+	r := tor.NewReader(context.Background())
+	defer r.Close()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := r.Read(make([]byte, 10))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, <-done)
+
+	// Without peers the next download ends at once
+	tor.Listening = false
+	err = tor.Download(context.Background())
+	var noPeers *NoPeersError
+	require.ErrorAs(t, err, &noPeers)
+	_, err = r.Read(make([]byte, 10))
+	assert.Equal(t, noPeers, err)
+}
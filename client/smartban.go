@@ -0,0 +1,180 @@
+package client
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/parkma99/go-bittorrent-client/peers"
+)
+
+// BanThreshold is the number of times a peer may be caught sending
+// corrupt data before it is banned
+const BanThreshold = 2
+
+// BanList holds the peers caught sending corrupt data. It can be shared by
+// several torrents so that a peer banned by one is banned by all. The zero
+// value is ready to use.
+type BanList struct {
+	mu      sync.Mutex
+	strikes map[string]int
+	banned  map[string]struct{}
+}
+
+// Banned reports whether ip was banned
+func (b *BanList) Banned(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.banned[ip.String()]
+	return ok
+}
+
+// Ban bans ip right away
+func (b *BanList) Ban(ip net.IP) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ban(ip.String())
+}
+
+// strike counts one offence of ip and reports whether ip is banned now
+func (b *BanList) strike(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := ip.String()
+	if b.strikes == nil {
+		b.strikes = make(map[string]int)
+	}
+	b.strikes[key]++
+	if b.strikes[key] >= BanThreshold {
+		b.ban(key)
+	}
+	_, ok := b.banned[key]
+	return ok
+}
+
+func (b *BanList) ban(key string) {
+	if b.banned == nil {
+		b.banned = make(map[string]struct{})
+	}
+	b.banned[key] = struct{}{}
+}
+
+// blockRecord is one block of a piece that failed its integrity check
+type blockRecord struct {
+	hash [20]byte
+	peer peers.Peer
+}
+
+// corruptPiece keeps the blocks of every failed copy of a piece until a
+// good copy shows which of them were bad. blocks is indexed by block.
+type corruptPiece struct {
+	blocks [][]blockRecord
+}
+
+// recordCorrupt remembers who sent each block of a copy of piece index
+// that failed its integrity check. It reports whether peer had already
+// sent a failed copy of the piece, which makes it the likely culprit.
+func (t *Torrent) recordCorrupt(index int, buf []byte, peer peers.Peer) (repeat bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.corrupt == nil {
+		t.corrupt = make(map[int]*corruptPiece)
+	}
+	cp := t.corrupt[index]
+	if cp == nil {
+		cp = &corruptPiece{blocks: make([][]blockRecord, numBlocks(len(buf)))}
+		t.corrupt[index] = cp
+	}
+	for _, records := range cp.blocks {
+		for _, r := range records {
+			if r.peer.IP.Equal(peer.IP) {
+				repeat = true
+			}
+		}
+	}
+	for i := range cp.blocks {
+		begin, end := blockBounds(i, len(buf))
+		cp.blocks[i] = append(cp.blocks[i], blockRecord{sha1.Sum(buf[begin:end]), peer})
+	}
+	return repeat
+}
+
+// blameCorrupt compares the good copy buf of piece index with the failed
+// copies recorded before and returns the peers that sent a block that
+// differs from it
+func (t *Torrent) blameCorrupt(index int, buf []byte) []peers.Peer {
+	t.mu.Lock()
+	cp := t.corrupt[index]
+	delete(t.corrupt, index)
+	t.mu.Unlock()
+	if cp == nil {
+		return nil
+	}
+	var culprits []peers.Peer
+	seen := make(map[string]bool)
+	for i, records := range cp.blocks {
+		begin, end := blockBounds(i, len(buf))
+		good := sha1.Sum(buf[begin:end])
+		for _, r := range records {
+			if r.hash != good && !seen[r.peer.IP.String()] {
+				seen[r.peer.IP.String()] = true
+				culprits = append(culprits, r.peer)
+			}
+		}
+	}
+	return culprits
+}
+
+// punish counts an offence of peer and, once it is banned, disconnects
+// every connection to it
+func (t *Torrent) punish(peer peers.Peer) {
+	log := t.logger()
+	if !t.banList().strike(peer.IP) {
+		log.Info("peer sent corrupt data", slog.String("peer", peer.String()))
+		return
+	}
+	log.Warn("banning peer for sending corrupt data", slog.String("peer", peer.String()))
+	t.Events.Publish(PeerBanned{Peer: peer})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.clients {
+		if c.peer.IP.Equal(peer.IP) {
+			c.conn.Close()
+		}
+	}
+}
+
+func (t *Torrent) banList() *BanList {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Bans == nil {
+		t.Bans = &BanList{}
+	}
+	return t.Bans
+}
+
+// refused returns why we do not talk to ip, or nil if we do
+func (t *Torrent) refused(ip net.IP) error {
+	if t.Blocklist.Blocked(ip) {
+		return fmt.Errorf("peer %s is blocked", ip)
+	}
+	if t.banList().Banned(ip) {
+		return fmt.Errorf("peer %s is banned", ip)
+	}
+	return nil
+}
+
+func numBlocks(length int) int {
+	return (length + MaxBlockSize - 1) / MaxBlockSize
+}
+
+func blockBounds(i, length int) (begin, end int) {
+	begin = i * MaxBlockSize
+	end = begin + MaxBlockSize
+	if end > length {
+		end = length
+	}
+	return begin, end
+}
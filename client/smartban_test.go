@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/parkma99/go-bittorrent-client/peers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlameCorrupt(t *testing.T) {
+	good := testData(3 * MaxBlockSize)
+	bad := append([]byte(nil), good...)
+	bad[MaxBlockSize+5] ^= 0xff
+
+	tor := &Torrent{}
+	honest := peers.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	poisoner := peers.Peer{IP: net.IPv4(10, 0, 0, 2), Port: 6881}
+
+	// Every block is recorded, the honest peer's copy only failed
+	// because of the other peer's block
+	assert.False(t, tor.recordCorrupt(0, good, honest))
+	assert.False(t, tor.recordCorrupt(0, bad, poisoner))
+	assert.True(t, tor.recordCorrupt(0, bad, poisoner))
+
+	assert.Equal(t, []peers.Peer{poisoner}, tor.blameCorrupt(0, good))
+	assert.Nil(t, tor.blameCorrupt(0, good))
+}
+
+func TestBanList(t *testing.T) {
+	var b BanList
+	ip := net.IPv4(10, 0, 0, 1)
+	for i := 1; i < BanThreshold; i++ {
+		assert.False(t, b.strike(ip))
+	}
+	assert.True(t, b.strike(ip))
+	assert.True(t, b.Banned(ip))
+	assert.False(t, b.Banned(net.IPv4(10, 0, 0, 2)))
+}
+
+func TestDownloadBansCorruptPeer(t *testing.T) {
+	data := testData(1000)
+	tor := newTestTorrent(data, 512)
+	seeder := newFakeSeeder(t, tor.InfoHash, data, 512, true)
+	tor.Peers = []peers.Peer{seeder.peer()}
+	tor.Events = &Bus{}
+	var banned []peers.Peer
+	tor.Events.Subscribe(func(e Event) {
+		if e, ok := e.(PeerBanned); ok {
+			banned = append(banned, e.Peer)
+		}
+	})
+
+	err := tor.Download(context.Background())
+	var noPeers *NoPeersError
+	require.True(t, errors.As(err, &noPeers))
+	assert.True(t, tor.Bans.Banned(seeder.peer().IP))
+	assert.Equal(t, []peers.Peer{seeder.peer()}, banned)
+
+	// Banned peers are not dialed again
+	err = tor.Download(context.Background())
+	require.True(t, errors.As(err, &noPeers))
+	assert.Len(t, banned, 1)
+}
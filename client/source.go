@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// maxSourceFailures is the number of failures in a row after which a
+// PieceSource is given up on. Busy replies count as failures.
+const maxSourceFailures = 5
+
+// minRetryAfter keeps a source that asks to be retried at once from being
+// hammered
+const minRetryAfter = 250 * time.Millisecond
+
+// MaxRetryAfter is the longest a busy PieceSource is waited for, longer
+// waits it asks for are shortened
+const MaxRetryAfter = 10 * time.Minute
+
+// A PieceSource fetches pieces by other means than the peer wire
+// protocol, such as a web seed
+type PieceSource interface {
+	// FetchPiece returns the length bytes of piece index, which start at
+	// byte begin of the torrent
+	FetchPiece(ctx context.Context, index, begin, length int) ([]byte, error)
+	String() string
+}
+
+// RetryAfterError is returned by a PieceSource that is busy and asks to
+// be tried again after a while. The source is waited for After, kept
+// between minRetryAfter and MaxRetryAfter, instead of the usual backoff.
+type RetryAfterError struct {
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return "source is busy, retry after " + e.After.String()
+}
+
+// startSource runs a worker downloading pieces from source
+func (t *Torrent) startSource(run *downloadRun, source PieceSource) {
+	run.spawn(func() { t.sourceWorker(run, source) })
+}
+
+func (t *Torrent) sourceWorker(run *downloadRun, source PieceSource) {
+	ctx := run.ctx
+	log := t.logger().With(slog.String("source", source.String()))
+	hasAll := func(int) bool { return true }
+	failures := 0
+	for failures < maxSourceFailures {
+		pw, err := run.picker.next(ctx, hasAll)
+		if err != nil {
+			return
+		}
+		begin, _ := t.calculateBoundsForPiece(pw.index)
+		buf, err := source.FetchPiece(ctx, pw.index, begin, pw.length)
+		if err == nil {
+			err = t.checkIntegrity(pw.index, buf)
+		}
+		if err != nil {
+			run.picker.add(pw.index) // Put piece back for other workers
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			var wait time.Duration
+			var retry *RetryAfterError
+			if errors.As(err, &retry) {
+				wait = min(max(retry.After, minRetryAfter), MaxRetryAfter)
+				log.Debug("source is busy", slog.Duration("retry after", wait))
+			} else {
+				log.Debug("could not fetch piece", slog.Int("piece", pw.index), slog.Any("error", err))
+				t.Events.Publish(PieceFailed{Index: pw.index, Err: err})
+				wait = time.Duration(failures) * time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		failures = 0
+		t.stats.payloadRead.Add(int64(len(buf)))
+
+		log.Debug("piece verified", slog.Int("piece", pw.index))
+		t.Events.Publish(PieceVerified{Index: pw.index})
+		select {
+		case run.results <- &pieceResult{pw.index, buf}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Warn("giving up on source")
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/parkma99/go-bittorrent-client/merkle"
+)
+
+// maxHashRequest is the most hashes a hash request may ask for
+const maxHashRequest = 512
+
+// FileV2 is a file of a BitTorrent v2 torrent (BEP 52). Every file starts
+// at a piece boundary, so its last piece is shorter than PieceLength
+// unless the file fills it.
+type FileV2 struct {
+	Length int
+	// PiecesRoot is the root of the merkle tree over the file's blocks
+	PiecesRoot [32]byte
+	// PieceLayer holds the hash of every piece of the file. It is nil
+	// when the file fits into one piece, whose hash is PiecesRoot.
+	PieceLayer [][32]byte
+}
+
+// pieceV2 is what verifies a piece of a v2 torrent
+type pieceV2 struct {
+	hash   [32]byte
+	length int
+	// leaves is the width of the piece's tree in blocks
+	leaves int
+}
+
+// layoutV2 maps the pieces of a v2 torrent to the files they belong to
+type layoutV2 struct {
+	pieceLength int
+	pieces      []pieceV2
+	files       map[[32]byte]*FileV2
+	// trees holds the tree above the piece layer of every file that has
+	// one, to answer hash requests from
+	trees map[[32]byte]*merkle.Tree
+}
+
+// v2 returns the layout of the pieces of FilesV2, nil for a v1 torrent
+func (t *Torrent) v2() *layoutV2 {
+	t.v2Once.Do(func() {
+		if t.FilesV2 == nil {
+			return
+		}
+		l := &layoutV2{
+			pieceLength: t.PieceLength,
+			files:       make(map[[32]byte]*FileV2),
+			trees:       make(map[[32]byte]*merkle.Tree),
+		}
+		perPiece := t.PieceLength / merkle.BlockSize
+		pad := merkle.PadHash(merkle.Log2(perPiece))
+		for i := range t.FilesV2 {
+			f := &t.FilesV2[i]
+			if f.Length == 0 {
+				continue
+			}
+			l.files[f.PiecesRoot] = f
+			if len(f.PieceLayer) == 0 {
+				blocks := (f.Length + merkle.BlockSize - 1) / merkle.BlockSize
+				l.pieces = append(l.pieces, pieceV2{f.PiecesRoot, f.Length, merkle.NextPowerOfTwo(blocks)})
+				continue
+			}
+			l.trees[f.PiecesRoot] = merkle.NewTree(f.PieceLayer, merkle.NextPowerOfTwo(len(f.PieceLayer)), pad)
+			for j, hash := range f.PieceLayer {
+				length := min(t.PieceLength, f.Length-j*t.PieceLength)
+				l.pieces = append(l.pieces, pieceV2{hash, length, perPiece})
+			}
+		}
+		t.layout = l
+	})
+	return t.layout
+}
+
+// numPieces returns the number of pieces of the torrent
+func (t *Torrent) numPieces() int {
+	if l := t.v2(); l != nil && len(t.PieceHashes) == 0 {
+		return len(l.pieces)
+	}
+	return len(t.PieceHashes)
+}
+
+// checkIntegrity checks buf against the SHA-1 hash of piece index, and
+// against its merkle tree for a v2 torrent. The last piece of a file of a
+// hybrid torrent ends with padding, which only the SHA-1 hash covers.
+func (t *Torrent) checkIntegrity(index int, buf []byte) error {
+	if index < len(t.PieceHashes) {
+		hash := sha1.Sum(buf)
+		if !bytes.Equal(hash[:], t.PieceHashes[index][:]) {
+			return fmt.Errorf("index %d failed integrity check", index)
+		}
+	}
+	if l := t.v2(); l != nil {
+		if index >= len(l.pieces) {
+			return fmt.Errorf("index %d has no merkle hash", index)
+		}
+		p := l.pieces[index]
+		if len(buf) < p.length || merkle.DataRoot(buf[:p.length], p.leaves) != p.hash {
+			return fmt.Errorf("index %d failed merkle integrity check", index)
+		}
+	}
+	return nil
+}
+
+// hashes answers a hash request from the piece layers, the only layer
+// we have for every file. ok is false when the request cannot be
+// answered.
+func (l *layoutV2) hashes(req hashRequest) (hashes [][32]byte, ok bool) {
+	f, tree := l.files[req.piecesRoot], l.trees[req.piecesRoot]
+	if f == nil || tree == nil {
+		return nil, false
+	}
+	pieceLayer := merkle.Log2(l.pieceLength / merkle.BlockSize)
+	width := merkle.NextPowerOfTwo(len(f.PieceLayer))
+	if req.baseLayer != pieceLayer || req.length < 1 || req.length > maxHashRequest ||
+		req.length != merkle.NextPowerOfTwo(req.length) || req.index%req.length != 0 ||
+		req.index < 0 || req.index+req.length > width {
+		return nil, false
+	}
+	pad := merkle.PadHash(pieceLayer)
+	for i := req.index; i < req.index+req.length; i++ {
+		if i < len(f.PieceLayer) {
+			hashes = append(hashes, f.PieceLayer[i])
+		} else {
+			hashes = append(hashes, pad)
+		}
+	}
+	return append(hashes, tree.Proof(req.index, req.length, req.proofLayers)...), true
+}
+
+// answerHashRequest sends the hashes a peer asked for, or rejects the
+// request. Requests are answered whenever the connection is read. We take
+// the piece layers from the metainfo and never send requests of our own.
+func (c *client) answerHashRequest(msg *message) error {
+	req, err := parseHashRequest(msg)
+	if err != nil {
+		return err
+	}
+	reply := formatHashReject(req)
+	if c.v2 != nil {
+		if hashes, ok := c.v2.hashes(req); ok {
+			reply = formatHashes(req, hashes)
+		}
+	}
+	c.trace("sent", reply)
+	_, err = c.conn.Write(reply.serialize())
+	return err
+}
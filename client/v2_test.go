@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"crypto/sha1"
+	"net"
+	"testing"
+
+	"github.com/parkma99/go-bittorrent-client/merkle"
+	"github.com/parkma99/go-bittorrent-client/peers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTorrentV2 returns a v2 torrent of files and the files laid out
+// as the torrent keeps them, each starting at a piece boundary
+func newTestTorrentV2(files [][]byte, pieceLength int) (*Torrent, []byte) {
+	t := &Torrent{
+		PeerID:      [20]byte{1, 2, 3},
+		InfoHash:    [20]byte{2},
+		PieceLength: pieceLength,
+		Name:        "test",
+	}
+	var layout []byte
+	for i, data := range files {
+		f := FileV2{Length: len(data)}
+		blocks := (len(data) + merkle.BlockSize - 1) / merkle.BlockSize
+		if len(data) <= pieceLength {
+			f.PiecesRoot = merkle.DataRoot(data, merkle.NextPowerOfTwo(blocks))
+		} else {
+			for begin := 0; begin < len(data); begin += pieceLength {
+				end := min(begin+pieceLength, len(data))
+				f.PieceLayer = append(f.PieceLayer, merkle.DataRoot(data[begin:end], pieceLength/merkle.BlockSize))
+			}
+			f.PiecesRoot = merkle.DataRoot(data, merkle.NextPowerOfTwo(blocks))
+		}
+		t.FilesV2 = append(t.FilesV2, f)
+		layout = append(layout, data...)
+		if i < len(files)-1 && len(layout)%pieceLength != 0 {
+			layout = append(layout, make([]byte, pieceLength-len(layout)%pieceLength)...)
+		}
+	}
+	t.Length = len(layout)
+	return t, layout
+}
+
+func TestDownloadV2(t *testing.T) {
+	const pieceLength = 2 * merkle.BlockSize
+	tor, layout := newTestTorrentV2([][]byte{testData(40000), testData(1000), nil, testData(70000)}, pieceLength)
+	assert.Equal(t, 2+1+3, tor.numPieces())
+	begin, end := tor.calculateBoundsForPiece(1)
+	assert.Equal(t, pieceLength, begin)
+	assert.Equal(t, 40000, end)
+
+	seeder := newFakeSeeder(t, tor.InfoHash, layout, pieceLength, false)
+	tor.Peers = []peers.Peer{seeder.peer()}
+	require.Nil(t, tor.Download(context.Background()))
+	assert.Equal(t, layout, readAll(t, tor))
+
+	r := tor.NewReader(context.Background())
+	defer r.Close()
+	read := make([]byte, len(layout))
+	_, err := r.ReadAt(read, 0)
+	require.Nil(t, err)
+	assert.Equal(t, layout, read)
+}
+
+func TestCheckIntegrityV2(t *testing.T) {
+	const pieceLength = 4 * merkle.BlockSize
+	data := testData(100000)
+	tor, _ := newTestTorrentV2([][]byte{data, data[:20000]}, pieceLength)
+
+	assert.Nil(t, tor.checkIntegrity(1, data[pieceLength:]))
+	assert.Nil(t, tor.checkIntegrity(2, data[:20000]))
+	assert.NotNil(t, tor.checkIntegrity(0, data[1:pieceLength+1]))
+	assert.NotNil(t, tor.checkIntegrity(2, data[:19999]))
+	assert.NotNil(t, tor.AddPiece(1, data[:pieceLength]))
+}
+
+func TestAnswerHashRequest(t *testing.T) {
+	const pieceLength = merkle.BlockSize
+	tor, _ := newTestTorrentV2([][]byte{testData(5 * pieceLength)}, pieceLength)
+	f := tor.FilesV2[0]
+	l := tor.v2()
+
+	req := hashRequest{piecesRoot: f.PiecesRoot, index: 2, length: 2, proofLayers: 10}
+	hashes, ok := l.hashes(req)
+	require.True(t, ok)
+	require.Len(t, hashes, 4)
+	assert.Equal(t, f.PieceLayer[2:4], hashes[:2])
+	assert.True(t, merkle.VerifyProof(f.PiecesRoot, hashes[:2], req.index, hashes[2:]))
+
+	// Past the end of the file the layer is padded
+	req = hashRequest{piecesRoot: f.PiecesRoot, index: 4, length: 4, proofLayers: 10}
+	hashes, ok = l.hashes(req)
+	require.True(t, ok)
+	assert.True(t, merkle.VerifyProof(f.PiecesRoot, hashes[:4], req.index, hashes[4:]))
+
+	for _, bad := range []hashRequest{
+		{piecesRoot: [32]byte{9}, length: 2},
+		{piecesRoot: f.PiecesRoot, baseLayer: 1, length: 2},
+		{piecesRoot: f.PiecesRoot, index: 1, length: 2},
+		{piecesRoot: f.PiecesRoot, length: 3},
+		{piecesRoot: f.PiecesRoot, length: 16},
+	} {
+		_, ok := l.hashes(bad)
+		assert.False(t, ok, "%+v", bad)
+	}
+}
+
+func TestHashMessages(t *testing.T) {
+	const pieceLength = merkle.BlockSize
+	tor, _ := newTestTorrentV2([][]byte{testData(3 * pieceLength)}, pieceLength)
+	f := tor.FilesV2[0]
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	c := clientConfig{v2: tor.v2()}.wrap(local, peers.Peer{})
+	assert.Equal(t, byte(reservedV2), clientConfig{v2: tor.v2()}.handshake().Reserved[7])
+
+	for _, req := range []hashRequest{
+		{piecesRoot: f.PiecesRoot, index: 0, length: 4, proofLayers: 1},
+		{piecesRoot: [32]byte{1}, index: 0, length: 4},
+	} {
+		go c.answerHashRequest(&message{ID: msgHashRequest, Payload: req.payload(0)})
+		msg, err := readMessage(remote)
+		require.Nil(t, err)
+		if req.piecesRoot != f.PiecesRoot {
+			assert.Equal(t, msgHashReject, msg.ID)
+			got, err := parseHashRequest(msg)
+			require.Nil(t, err)
+			assert.Equal(t, req, got)
+			continue
+		}
+		assert.Equal(t, msgHashes, msg.ID)
+		assert.Equal(t, req, parseHashRequestPayload(msg.Payload))
+		var hashes [][32]byte
+		for rest := msg.Payload[hashRequestLength:]; len(rest) >= 32; rest = rest[32:] {
+			hashes = append(hashes, [32]byte(rest[:32]))
+		}
+		assert.Equal(t, f.PieceLayer, hashes[:3])
+		assert.Len(t, hashes, 4)
+	}
+}
+
+// newTestTorrentHybrid returns a hybrid torrent of files, which pads every
+// file but the last to a piece boundary, and its files laid out
+func newTestTorrentHybrid(files [][]byte, pieceLength int) (*Torrent, []byte) {
+	t, layout := newTestTorrentV2(files, pieceLength)
+	for begin := 0; begin < len(layout); begin += pieceLength {
+		t.PieceHashes = append(t.PieceHashes, sha1.Sum(layout[begin:min(begin+pieceLength, len(layout))]))
+	}
+	t.InfoHashV2 = [20]byte{3}
+	return t, layout
+}
+
+func TestDownloadHybrid(t *testing.T) {
+	const pieceLength = 2 * merkle.BlockSize
+	tor, layout := newTestTorrentHybrid([][]byte{testData(40000), testData(70000)}, pieceLength)
+	assert.Equal(t, 2+3, tor.numPieces())
+	begin, end := tor.calculateBoundsForPiece(1)
+	assert.Equal(t, pieceLength, begin)
+	assert.Equal(t, 2*pieceLength, end)
+
+	// Padding that is not zero passes the merkle check but not SHA-1
+	piece := append([]byte(nil), layout[pieceLength:2*pieceLength]...)
+	assert.Nil(t, tor.checkIntegrity(1, piece))
+	piece[len(piece)-1] = 1
+	assert.NotNil(t, tor.checkIntegrity(1, piece))
+
+	// The only seeder is in the v2 swarm
+	seeder := newFakeSeeder(t, tor.InfoHashV2, layout, pieceLength, false)
+	tor.PeersV2 = []peers.Peer{seeder.peer()}
+	require.Nil(t, tor.Download(context.Background()))
+	assert.Equal(t, layout, readAll(t, tor))
+}
+
+func TestAddConnInfoHash(t *testing.T) {
+	tor, _ := newTestTorrentHybrid([][]byte{testData(1000)}, merkle.BlockSize)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	assert.ErrorContains(t, tor.AddConn(local, [20]byte{9}, [20]byte{}), "info hash")
+	local, remote = net.Pipe()
+	defer remote.Close()
+	// The v2 info hash is accepted, but nothing is downloading
+	assert.ErrorContains(t, tor.AddConn(local, tor.InfoHashV2, [20]byte{}), "not downloading")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parkma99/go-bittorrent-client/torrentfile"
+)
+
+// create makes a .torrent file of a file or directory:
+//
+//	go-bittorrent-client create [flags] path
+func create(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s create [flags] path\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	outPath := fs.String("o", "", "write the torrent to this file, NAME.torrent by default")
+	announce := fs.String("announce", "", "comma separated trackers, each in a tier of its own")
+	comment := fs.String("comment", "", "free-form comment")
+	hybrid := fs.Bool("hybrid", false, "add v2 metadata so the torrent is in the v1 and the v2 swarm")
+	createdBy := fs.String("created-by", torrentfile.DefaultCreatedBy, "program that created the torrent")
+	name := fs.String("name", "", "name of the torrent, the base name of path by default")
+	pieceLength := fs.Int("piece-length", 0, "piece length in bytes, chosen from the total size by default")
+	private := fs.Bool("private", false, "only get peers from the trackers")
+	webSeeds := fs.String("web-seed", "", "comma separated web seed URLs")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	root := fs.Arg(0)
+
+	opts := torrentfile.CreateOptions{
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		Hybrid:      *hybrid,
+		Name:        *name,
+		PieceLength: *pieceLength,
+		Private:     *private,
+	}
+	if *announce != "" {
+		for _, tracker := range strings.Split(*announce, ",") {
+			opts.AnnounceList = append(opts.AnnounceList, []string{tracker})
+		}
+		if len(opts.AnnounceList) == 1 {
+			opts.Announce, opts.AnnounceList = opts.AnnounceList[0][0], nil
+		}
+	}
+	if *webSeeds != "" {
+		opts.URLList = strings.Split(*webSeeds, ",")
+	}
+	if *outPath == "" {
+		base := opts.Name
+		if base == "" {
+			base = filepath.Base(filepath.Clean(root))
+		}
+		*outPath = base + ".torrent"
+	}
+
+	f, err := os.Create(*outPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tf, err := torrentfile.Create(f, root, opts)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+		os.Remove(*outPath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s: %d pieces of %d bytes, info hash %x\n",
+		*outPath, len(tf.PieceHashes), tf.PieceLength, tf.InfoHash)
+}
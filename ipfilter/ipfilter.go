@@ -0,0 +1,275 @@
+// Package ipfilter blocks peers by IP address range. Ranges are loaded from
+// blocklists in the P2P plaintext, eMule DAT or CIDR formats.
+package ipfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Filter is a set of blocked address ranges. The zero value blocks
+// nothing and a nil *Filter blocks nothing either. A Filter is safe for
+// concurrent use.
+type Filter struct {
+	mu sync.RWMutex
+	// ranges are sorted by start and never overlap
+	ranges []ipRange
+}
+
+// ipRange is an inclusive range of addresses in their 16 byte form
+type ipRange struct {
+	start, end net.IP
+}
+
+// New returns an empty filter
+func New() *Filter {
+	return &Filter{}
+}
+
+// LoadFile adds the ranges listed in the blocklist at path, see Load
+func (f *Filter) LoadFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	n, err := f.Load(file)
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", path, err)
+	}
+	return n, nil
+}
+
+// Load adds the ranges read from r and returns how many it read. Each
+// line is in one of the formats
+//
+//	Some description:1.2.3.0-1.2.3.255           (P2P plaintext)
+//	001.002.003.000 - 001.002.003.255 , 000 , Description   (eMule DAT)
+//	1.2.3.0/24                                   (CIDR)
+//	1.2.3.4                                      (single address)
+//
+// Empty lines and lines starting with # or // are skipped. DAT entries
+// with an access level above 127 are allowed and not added. Nothing is
+// added if a line cannot be parsed.
+func (f *Filter) Load(r io.Reader) (int, error) {
+	var ranges []ipRange
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+			continue
+		}
+		rng, blocked, err := parseLine(text)
+		if err != nil {
+			return 0, fmt.Errorf("ipfilter: line %d: %w", line, err)
+		}
+		if blocked {
+			ranges = append(ranges, rng)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	f.add(ranges...)
+	return len(ranges), nil
+}
+
+// AddRange blocks every address from start to end inclusive
+func (f *Filter) AddRange(start, end net.IP) error {
+	rng, err := newRange(start, end)
+	if err != nil {
+		return err
+	}
+	f.add(rng)
+	return nil
+}
+
+// AddCIDR blocks the network given in CIDR notation, such as 10.0.0.0/8
+func (f *Filter) AddCIDR(cidr string) error {
+	rng, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	f.add(rng)
+	return nil
+}
+
+// Blocked reports whether ip is in one of the blocked ranges
+func (f *Filter) Blocked(ip net.IP) bool {
+	if f == nil {
+		return false
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	// The first range ending at or after ip is the only candidate
+	i := sort.Search(len(f.ranges), func(i int) bool {
+		return bytes.Compare(f.ranges[i].end, ip16) >= 0
+	})
+	return i < len(f.ranges) && bytes.Compare(f.ranges[i].start, ip16) <= 0
+}
+
+// Len returns the number of disjoint ranges in the filter
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.ranges)
+}
+
+// add merges ranges into the filter, keeping f.ranges sorted and disjoint
+func (f *Filter) add(ranges ...ipRange) {
+	if len(ranges) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all := append(f.ranges, ranges...)
+	sort.Slice(all, func(i, j int) bool {
+		return bytes.Compare(all[i].start, all[j].start) < 0
+	})
+	merged := all[:1]
+	for _, rng := range all[1:] {
+		last := &merged[len(merged)-1]
+		if bytes.Compare(rng.start, last.end) <= 0 || adjacent(last.end, rng.start) {
+			if bytes.Compare(rng.end, last.end) > 0 {
+				last.end = rng.end
+			}
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	f.ranges = merged
+}
+
+// adjacent reports whether b directly follows a
+func adjacent(a, b net.IP) bool {
+	next := make(net.IP, len(a))
+	copy(next, a)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next.Equal(b)
+		}
+	}
+	return false // a was the last address
+}
+
+// parseLine parses one blocklist entry. blocked is false for DAT entries
+// whose access level allows the range.
+func parseLine(text string) (rng ipRange, blocked bool, err error) {
+	// DAT: range , level , description
+	if fields := strings.Split(text, ","); len(fields) >= 2 {
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err == nil {
+			rng, err := parseRange(fields[0])
+			if err != nil {
+				return ipRange{}, false, err
+			}
+			return rng, level <= 127, nil
+		}
+	}
+	if _, _, err := net.ParseCIDR(text); err == nil {
+		rng, err := parseCIDR(text)
+		return rng, true, err
+	}
+	// P2P: description:range, the description may contain colons itself
+	if i := strings.LastIndex(text, ":"); i >= 0 && strings.Contains(text[i:], "-") {
+		rng, err := parseRange(text[i+1:])
+		return rng, true, err
+	}
+	if strings.Contains(text, "-") {
+		rng, err := parseRange(text)
+		return rng, true, err
+	}
+	ip := parseIP(text)
+	if ip == nil {
+		return ipRange{}, false, fmt.Errorf("unrecognized entry %q", text)
+	}
+	return ipRange{ip, ip}, true, nil
+}
+
+// parseRange parses "start-end", with optional spaces around the dash
+func parseRange(s string) (ipRange, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return ipRange{}, fmt.Errorf("invalid range %q", strings.TrimSpace(s))
+	}
+	start, end := parseIP(startStr), parseIP(endStr)
+	if start == nil || end == nil {
+		return ipRange{}, fmt.Errorf("invalid range %q", strings.TrimSpace(s))
+	}
+	return newRange(start, end)
+}
+
+func parseCIDR(s string) (ipRange, error) {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(s))
+	if err != nil {
+		return ipRange{}, err
+	}
+	start := ipNet.IP.To16()
+	end := make(net.IP, len(start))
+	// An IPv4 mask only covers the last four bytes of the 16 byte form
+	mask := ipNet.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range start {
+		end[i] = start[i] | ^mask[i]
+	}
+	return ipRange{start, end}, nil
+}
+
+func newRange(start, end net.IP) (ipRange, error) {
+	start16, end16 := start.To16(), end.To16()
+	if start16 == nil || end16 == nil {
+		return ipRange{}, fmt.Errorf("invalid range %s-%s", start, end)
+	}
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return ipRange{}, fmt.Errorf("range %s-%s mixes IPv4 and IPv6", start, end)
+	}
+	if bytes.Compare(start16, end16) > 0 {
+		return ipRange{}, fmt.Errorf("range %s-%s ends before it starts", start, end)
+	}
+	return ipRange{start16, end16}, nil
+}
+
+// parseIP is net.ParseIP that also accepts the zero padded IPv4 addresses
+// common in DAT files, such as 001.002.003.000
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16()
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	for i, part := range parts {
+		if part == "" || len(part) > 3 {
+			return nil
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 255 {
+			return nil
+		}
+		ip[i] = byte(n)
+	}
+	return ip.To16()
+}
@@ -0,0 +1,74 @@
+package ipfilter
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	blocklist := `# comment
+// another comment
+
+Some Corp, Inc: Level 1:1.2.3.0-1.2.3.255
+010.000.000.000 - 010.000.000.255 , 000 , DAT entry
+010.000.001.000 - 010.000.001.255 , 200 , allowed DAT entry
+192.168.0.0/16
+2001:db8::/32
+8.8.8.8
+`
+	f := New()
+	n, err := f.Load(strings.NewReader(blocklist))
+	require.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	tests := map[string]struct {
+		ip      string
+		blocked bool
+	}{
+		"p2p start":         {"1.2.3.0", true},
+		"p2p end":           {"1.2.3.255", true},
+		"after p2p":         {"1.2.4.0", false},
+		"dat":               {"10.0.0.7", true},
+		"allowed dat":       {"10.0.1.7", false},
+		"cidr":              {"192.168.44.1", true},
+		"ipv6 cidr":         {"2001:db8::1", true},
+		"ipv6 outside":      {"2001:db9::1", false},
+		"single":            {"8.8.8.8", true},
+		"next to single":    {"8.8.8.9", false},
+		"before everything": {"0.0.0.1", false},
+	}
+	for name, test := range tests {
+		assert.Equal(t, test.blocked, f.Blocked(net.ParseIP(test.ip)), name)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	f := New()
+	_, err := f.Load(strings.NewReader("1.2.3.0/24\nnot an address\n"))
+	assert.ErrorContains(t, err, "line 2")
+	assert.Equal(t, 0, f.Len())
+
+	_, err = f.Load(strings.NewReader("bad:1.2.3.255-1.2.3.0\n"))
+	assert.NotNil(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	f := New()
+	require.Nil(t, f.AddRange(net.ParseIP("1.0.0.0"), net.ParseIP("1.0.0.10")))
+	require.Nil(t, f.AddRange(net.ParseIP("1.0.0.11"), net.ParseIP("1.0.0.20")))
+	require.Nil(t, f.AddRange(net.ParseIP("1.0.0.5"), net.ParseIP("1.0.0.15")))
+	require.Nil(t, f.AddCIDR("3.0.0.0/8"))
+	assert.Equal(t, 2, f.Len())
+	assert.True(t, f.Blocked(net.ParseIP("1.0.0.20")))
+	assert.False(t, f.Blocked(net.ParseIP("1.0.0.21")))
+	assert.True(t, f.Blocked(net.ParseIP("3.255.255.255")))
+
+	assert.NotNil(t, f.AddRange(net.ParseIP("1.0.0.0"), net.ParseIP("::1")))
+
+	var nilFilter *Filter
+	assert.False(t, nilFilter.Blocked(net.ParseIP("1.0.0.1")))
+}
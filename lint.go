@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/parkma99/go-bittorrent-client/torrentfile"
+)
+
+// lint checks .torrent files. Errors make a torrent unusable, warnings
+// point out things that are legal but unusual. It exits with status 1 if
+// any torrent has an error, and with -strict also on warnings:
+//
+//	go-bittorrent-client lint [flags] file...
+func lint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint [flags] file...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		tf, err := torrentfile.Open(path)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", path, err)
+			failed = true
+			continue
+		}
+		warnings := tf.Lint()
+		for _, w := range warnings {
+			fmt.Printf("%s: warning: %s\n", path, w)
+		}
+		if *strict && len(warnings) > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
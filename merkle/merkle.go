@@ -0,0 +1,138 @@
+// Package merkle computes the SHA-256 merkle trees of BitTorrent v2
+// (BEP 52). The leaves of a tree are the hashes of the 16 KiB blocks of a
+// file, and a layer that does not fill its tree is padded up to a power of
+// two.
+package merkle
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// BlockSize is the number of bytes hashed into every leaf
+const BlockSize = 16 << 10
+
+func hashPair(left, right [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], left[:])
+	copy(buf[32:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// NextPowerOfTwo returns the smallest power of two that is at least n
+func NextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// Log2 returns the exponent of the power of two n
+func Log2(n int) int {
+	return bits.Len(uint(n)) - 1
+}
+
+// PadHash returns the root of a tree of 2^height zero leaves, which pads
+// layer height of a tree
+func PadHash(height int) [32]byte {
+	var h [32]byte
+	for i := 0; i < height; i++ {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+// layers returns every layer of the tree whose lowest layer is hashes,
+// padded to width nodes with pad, from the bottom up to the root. width
+// must be a power of two and at least len(hashes).
+func layers(hashes [][32]byte, width int, pad [32]byte) [][][32]byte {
+	layer := make([][32]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	tree := [][][32]byte{layer}
+	for len(layer) > 1 {
+		up := make([][32]byte, len(layer)/2)
+		for i := range up {
+			up[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		tree = append(tree, up)
+		layer = up
+	}
+	return tree
+}
+
+// Root returns the root of the tree whose lowest layer is hashes, padded
+// to width nodes with pad. width must be a power of two and at least
+// len(hashes).
+func Root(hashes [][32]byte, width int, pad [32]byte) [32]byte {
+	tree := layers(hashes, width, pad)
+	return tree[len(tree)-1][0]
+}
+
+// BlockHashes returns the leaves of data, the hashes of its 16 KiB blocks.
+// The last block may be shorter.
+func BlockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for begin := 0; begin < len(data); begin += BlockSize {
+		hashes = append(hashes, sha256.Sum256(data[begin:min(begin+BlockSize, len(data))]))
+	}
+	return hashes
+}
+
+// DataRoot returns the root of a tree of leaves leaves over the blocks of
+// data. The leaves past the end of data are zero.
+func DataRoot(data []byte, leaves int) [32]byte {
+	return Root(BlockHashes(data), leaves, [32]byte{})
+}
+
+// Tree keeps every layer of a merkle tree, so that proofs are read from it
+// instead of hashing the tree again
+type Tree struct {
+	layers [][][32]byte
+}
+
+// NewTree builds the tree whose lowest layer is hashes, padded to width
+// nodes with pad. width must be a power of two and at least len(hashes).
+func NewTree(hashes [][32]byte, width int, pad [32]byte) *Tree {
+	return &Tree{layers: layers(hashes, width, pad)}
+}
+
+// Root returns the root of the tree
+func (t *Tree) Root() [32]byte {
+	return t.layers[len(t.layers)-1][0]
+}
+
+// Proof returns the uncle hashes that prove the count nodes starting at
+// index of the lowest layer, from the bottom up. At most proofLayers
+// hashes are returned, and none for the root.
+func (t *Tree) Proof(index, count, proofLayers int) [][32]byte {
+	var proof [][32]byte
+	node := index / count
+	for h := Log2(count); h < len(t.layers)-1 && len(proof) < proofLayers; h++ {
+		proof = append(proof, t.layers[h][node^1])
+		node /= 2
+	}
+	return proof
+}
+
+// VerifyProof reports whether the hashes starting at index of a layer lead
+// to root with the uncle hashes of proof, as returned by Tree.Proof. The
+// number of hashes must be a power of two.
+func VerifyProof(root [32]byte, hashes [][32]byte, index int, proof [][32]byte) bool {
+	if len(hashes) == 0 {
+		return false
+	}
+	node := index / len(hashes)
+	h := Root(hashes, len(hashes), [32]byte{})
+	for _, uncle := range proof {
+		if node%2 == 0 {
+			h = hashPair(h, uncle)
+		} else {
+			h = hashPair(uncle, h)
+		}
+		node /= 2
+	}
+	return h == root
+}
@@ -0,0 +1,71 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPadHash(t *testing.T) {
+	var zero [32]byte
+	assert.Equal(t, zero, PadHash(0))
+	assert.Equal(t, hashPair(zero, zero), PadHash(1))
+	assert.Equal(t, hashPair(PadHash(1), PadHash(1)), PadHash(2))
+}
+
+func TestDataRoot(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, BlockSize+10)
+	a := sha256.Sum256(data[:BlockSize])
+	b := sha256.Sum256(data[BlockSize:])
+
+	assert.Equal(t, hashPair(a, b), DataRoot(data, 2))
+	var zero [32]byte
+	assert.Equal(t, hashPair(hashPair(a, b), hashPair(zero, zero)), DataRoot(data, 4))
+	assert.Equal(t, a, DataRoot(data[:BlockSize], 1))
+}
+
+func TestRootPadding(t *testing.T) {
+	// A piece layer padded with the roots of empty pieces gives the same
+	// root as the leaves padded with zeros
+	data := bytes.Repeat([]byte{7}, 5*BlockSize)
+	const leavesPerPiece = 2
+	var pieces [][32]byte
+	for begin := 0; begin < len(data); begin += leavesPerPiece * BlockSize {
+		pieces = append(pieces, DataRoot(data[begin:min(begin+leavesPerPiece*BlockSize, len(data))], leavesPerPiece))
+	}
+	assert.Len(t, pieces, 3)
+	assert.Equal(t, DataRoot(data, 8), Root(pieces, 4, PadHash(Log2(leavesPerPiece))))
+}
+
+func TestProof(t *testing.T) {
+	var hashes [][32]byte
+	for i := 0; i < 6; i++ {
+		hashes = append(hashes, sha256.Sum256([]byte{byte(i)}))
+	}
+	pad := PadHash(3)
+	tree := NewTree(hashes, 8, pad)
+	root := Root(hashes, 8, pad)
+	assert.Equal(t, root, tree.Root())
+
+	proof := tree.Proof(2, 2, 10)
+	assert.Len(t, proof, 2)
+	assert.True(t, VerifyProof(root, hashes[2:4], 2, proof))
+	assert.False(t, VerifyProof(root, hashes[2:4], 0, proof))
+	assert.False(t, VerifyProof(root, hashes[0:2], 2, proof))
+
+	proof = tree.Proof(4, 4, 10)
+	assert.Len(t, proof, 1)
+	assert.True(t, VerifyProof(root, [][32]byte{hashes[4], hashes[5], pad, pad}, 4, proof))
+
+	assert.Len(t, tree.Proof(0, 2, 1), 1)
+}
+
+func TestNextPowerOfTwo(t *testing.T) {
+	assert.Equal(t, 1, NextPowerOfTwo(0))
+	assert.Equal(t, 1, NextPowerOfTwo(1))
+	assert.Equal(t, 4, NextPowerOfTwo(3))
+	assert.Equal(t, 4, NextPowerOfTwo(4))
+	assert.Equal(t, 3, Log2(8))
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	typeCounter metricType = "counter"
+	typeGauge   metricType = "gauge"
+	typeSummary metricType = "summary"
+)
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format. It implements http.Handler so it can be mounted on
+// /metrics directly.
+type Registry struct {
+	mu       sync.Mutex
+	families []*Vec
+	hooks    []func()
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Vec is a metric family, partitioned by label values
+type Vec struct {
+	name   string
+	help   string
+	typ    metricType
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*Value
+}
+
+// Value is a single time series of a Vec. Summaries use Observe, counters
+// and gauges the remaining methods.
+type Value struct {
+	labelValues []string
+
+	mu    sync.Mutex
+	val   float64
+	count uint64
+}
+
+// NewCounter registers a counter family
+func (r *Registry) NewCounter(name, help string, labels ...string) *Vec {
+	return r.register(name, help, typeCounter, labels)
+}
+
+// NewGauge registers a gauge family
+func (r *Registry) NewGauge(name, help string, labels ...string) *Vec {
+	return r.register(name, help, typeGauge, labels)
+}
+
+// NewSummary registers a summary family reporting the sum and count of
+// observations
+func (r *Registry) NewSummary(name, help string, labels ...string) *Vec {
+	return r.register(name, help, typeSummary, labels)
+}
+
+// OnCollect registers fn to be called before every scrape, so that values
+// kept elsewhere can be copied into the registry
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+func (r *Registry) register(name, help string, typ metricType, labels []string) *Vec {
+	v := &Vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]*Value),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, v)
+	return v
+}
+
+// With returns the time series for the given label values, creating it
+// if necessary. It panics if the number of values does not match the
+// labels of the family.
+func (v *Vec) With(labelValues ...string) *Value {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val, ok := v.values[key]
+	if !ok {
+		val = &Value{labelValues: labelValues}
+		v.values[key] = val
+	}
+	return val
+}
+
+// Delete removes the time series for the given label values
+func (v *Vec) Delete(labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.values, strings.Join(labelValues, "\xff"))
+}
+
+// Add adds delta to the value
+func (val *Value) Add(delta float64) {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	val.val += delta
+}
+
+// Inc adds one to the value
+func (val *Value) Inc() {
+	val.Add(1)
+}
+
+// Set replaces the value
+func (val *Value) Set(v float64) {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	val.val = v
+}
+
+// Observe records one observation of a summary
+func (val *Value) Observe(v float64) {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	val.val += v
+	val.count++
+}
+
+func (val *Value) get() (float64, uint64) {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	return val.val, val.count
+}
+
+// WriteTo writes every metric in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	families := append([]*Vec{}, r.families...)
+	r.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (v *Vec) write(w *countingWriter) {
+	v.mu.Lock()
+	values := make([]*Value, 0, len(v.values))
+	for _, val := range v.values {
+		values = append(values, val)
+	}
+	v.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return strings.Join(values[i].labelValues, "\xff") < strings.Join(values[j].labelValues, "\xff")
+	})
+
+	w.printf("# HELP %s %s\n", v.name, escapeHelp(v.help))
+	w.printf("# TYPE %s %s\n", v.name, v.typ)
+	for _, val := range values {
+		labels := formatLabels(v.labels, val.labelValues)
+		sum, count := val.get()
+		if v.typ == typeSummary {
+			w.printf("%s_sum%s %s\n", v.name, labels, formatFloat(sum))
+			w.printf("%s_count%s %d\n", v.name, labels, count)
+			continue
+		}
+		w.printf("%s%s %s\n", v.name, labels, formatFloat(sum))
+	}
+}
+
+// ServeHTTP serves the metrics to a scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
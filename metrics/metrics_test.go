@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("requests_total", "Requests served.", "code")
+	gauge := r.NewGauge("temperature", "Current temperature.")
+	summary := r.NewSummary("latency_seconds", "Request latency.", "path")
+
+	counter.With("200").Add(3)
+	counter.With("500").Inc()
+	counter.With(`a"b`).Inc()
+	gauge.With().Set(21.5)
+	summary.With("/").Observe(0.5)
+	summary.With("/").Observe(1.5)
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="500"} 1
+requests_total{code="a\"b"} 1
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 21.5
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds summary
+latency_seconds_sum{path="/"} 2
+latency_seconds_count{path="/"} 2
+`
+	buf := &bytes.Buffer{}
+	n, err := r.WriteTo(buf)
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), n)
+	assert.Equal(t, expected, buf.String())
+
+	counter.Delete("500")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), `code="500"`)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestCollector(t *testing.T) {
+	r := NewRegistry()
+	c := NewCollector(r)
+	tor := &client.Torrent{InfoHash: [20]byte{0xab}, Events: &client.Bus{}}
+	untrack := c.Track(tor)
+
+	tor.Events.Publish(client.PieceVerified{Index: 0})
+	tor.Events.Publish(client.PieceVerified{Index: 1})
+	tor.Events.Publish(client.PieceFailed{Index: 2})
+	tor.Events.Publish(client.TrackerAnnounce{Duration: 250 * time.Millisecond})
+
+	buf := &bytes.Buffer{}
+	_, err := r.WriteTo(buf)
+	require.Nil(t, err)
+	out := buf.String()
+	const label = `infohash="ab00000000000000000000000000000000000000"`
+	assert.Contains(t, out, `bittorrent_pieces_total{`+label+`,result="verified"} 2`)
+	assert.Contains(t, out, `bittorrent_pieces_total{`+label+`,result="failed"} 1`)
+	assert.Contains(t, out, `bittorrent_tracker_announce_duration_seconds_sum{`+label+`} 0.25`)
+	assert.Contains(t, out, `bittorrent_peers{`+label+`} 0`)
+	assert.Contains(t, out, `bittorrent_bytes_total{`+label+`,direction="down",kind="payload"} 0`)
+	assert.Contains(t, out, "bittorrent_bencode_decode_errors_total ")
+
+	untrack()
+	buf.Reset()
+	_, err = r.WriteTo(buf)
+	require.Nil(t, err)
+	assert.NotContains(t, buf.String(), label)
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+	"github.com/parkma99/go-bittorrent-client/client"
+)
+
+// Collector exports the state of running torrents to a Registry
+type Collector struct {
+	bytes            *Vec
+	peers            *Vec
+	peerStates       *Vec
+	pieces           *Vec
+	announceDuration *Vec
+	announceErrors   *Vec
+	decodeErrors     *Vec
+
+	mu       sync.Mutex
+	torrents map[*client.Torrent]struct{}
+}
+
+// NewCollector registers the torrent metrics on r
+func NewCollector(r *Registry) *Collector {
+	c := &Collector{
+		bytes: r.NewCounter("bittorrent_bytes_total",
+			"Bytes exchanged with peers.", "infohash", "direction", "kind"),
+		peers: r.NewGauge("bittorrent_peers",
+			"Connected peers.", "infohash"),
+		peerStates: r.NewGauge("bittorrent_peers_choke_state",
+			"Connected peers by whether they choke us.", "infohash", "state"),
+		pieces: r.NewCounter("bittorrent_pieces_total",
+			"Pieces checked against their hash.", "infohash", "result"),
+		announceDuration: r.NewSummary("bittorrent_tracker_announce_duration_seconds",
+			"Time taken by tracker announces.", "infohash"),
+		announceErrors: r.NewCounter("bittorrent_tracker_announce_errors_total",
+			"Failed tracker announces.", "infohash"),
+		decodeErrors: r.NewCounter("bittorrent_bencode_decode_errors_total",
+			"Bencoded data that could not be decoded."),
+		torrents: make(map[*client.Torrent]struct{}),
+	}
+	r.OnCollect(c.collect)
+	return c
+}
+
+// Track exports the metrics of t until the returned function is called.
+// Events are read from t.Events, which must not be nil.
+func (c *Collector) Track(t *client.Torrent) (untrack func()) {
+	infoHash := hex.EncodeToString(t.InfoHash[:])
+	unsubscribe := t.Events.Subscribe(func(e client.Event) {
+		switch e := e.(type) {
+		case client.PieceVerified:
+			c.pieces.With(infoHash, "verified").Inc()
+		case client.PieceFailed:
+			c.pieces.With(infoHash, "failed").Inc()
+		case client.TrackerAnnounce:
+			c.announceDuration.With(infoHash).Observe(e.Duration.Seconds())
+			if e.Err != nil {
+				c.announceErrors.With(infoHash).Inc()
+			}
+		}
+	})
+
+	c.mu.Lock()
+	c.torrents[t] = struct{}{}
+	c.mu.Unlock()
+
+	return func() {
+		unsubscribe()
+		c.mu.Lock()
+		delete(c.torrents, t)
+		c.mu.Unlock()
+		for _, direction := range []string{"down", "up"} {
+			for _, kind := range []string{"payload", "overhead"} {
+				c.bytes.Delete(infoHash, direction, kind)
+			}
+		}
+		c.peers.Delete(infoHash)
+		c.peerStates.Delete(infoHash, "choked")
+		c.peerStates.Delete(infoHash, "unchoked")
+		c.pieces.Delete(infoHash, "verified")
+		c.pieces.Delete(infoHash, "failed")
+		c.announceDuration.Delete(infoHash)
+		c.announceErrors.Delete(infoHash)
+	}
+}
+
+// collect copies the values kept by the torrents themselves
+func (c *Collector) collect() {
+	c.decodeErrors.With().Set(float64(bencode.DecodeErrors()))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t := range c.torrents {
+		infoHash := hex.EncodeToString(t.InfoHash[:])
+		stats := t.Stats()
+		c.bytes.With(infoHash, "down", "payload").Set(float64(stats.PayloadDownloaded))
+		c.bytes.With(infoHash, "down", "overhead").Set(float64(stats.OverheadDownloaded))
+		c.bytes.With(infoHash, "up", "payload").Set(float64(stats.PayloadUploaded))
+		c.bytes.With(infoHash, "up", "overhead").Set(float64(stats.OverheadUploaded))
+
+		choked, unchoked := t.PeerStates()
+		c.peers.With(infoHash).Set(float64(choked + unchoked))
+		c.peerStates.With(infoHash, "choked").Set(float64(choked))
+		c.peerStates.With(infoHash, "unchoked").Set(float64(unchoked))
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeHTTPProxy answers CONNECT requests carrying the expected
+// Proxy-Authorization header, if any
+func newFakeHTTPProxy(t *testing.T, auth string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echo := newEchoServer(t)
+	proxyLn := newFakeHTTPProxy(t, "Basic dXNlcjpzZWNyZXQ=") // user:secret
+
+	d := &HTTPConnect{Addr: proxyLn.Addr().String(), Username: "user", Password: "secret"}
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	d.Password = "wrong"
+	_, err = d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	assert.ErrorContains(t, err, "407")
+}
+
+func TestFromURL(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		output Dialer
+		fails  bool
+	}{
+		"socks5": {
+			input:  "socks5://user:pw@127.0.0.1:1080",
+			output: &SOCKS5{Addr: "127.0.0.1:1080", Username: "user", Password: "pw", ResolveLocally: true},
+		},
+		"socks5h": {
+			input:  "socks5h://proxy:1080",
+			output: &SOCKS5{Addr: "proxy:1080"},
+		},
+		"http": {
+			input:  "http://proxy:3128",
+			output: &HTTPConnect{Addr: "proxy:3128"},
+		},
+		"unsupported": {
+			input: "ftp://proxy:21",
+			fails: true,
+		},
+	}
+	for name, test := range tests {
+		u, err := url.Parse(test.input)
+		require.Nil(t, err)
+		d, err := FromURL(u)
+		if test.fails {
+			assert.NotNil(t, err, name)
+			continue
+		}
+		assert.Nil(t, err, name)
+		assert.Equal(t, test.output, d, name)
+	}
+}
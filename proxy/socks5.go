@@ -0,0 +1,362 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const socksVersion = 5
+
+const (
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksNoAcceptable = 0xff
+)
+
+const (
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+)
+
+const (
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+)
+
+// SOCKS5 dials through a SOCKS5 proxy (RFC 1928), optionally with
+// username and password authentication (RFC 1929)
+type SOCKS5 struct {
+	Addr     string
+	Username string
+	Password string
+	// ResolveLocally looks host names up before sending them to the proxy,
+	// like the socks5 URL scheme. Otherwise the proxy resolves them, like
+	// socks5h, and no DNS query leaves this host.
+	ResolveLocally bool
+	// Forward is used to reach the proxy itself, nil means Direct
+	Forward Dialer
+}
+
+// DialContext connects to address through the proxy. Only TCP networks
+// are supported, use ListenPacket for UDP.
+func (s *SOCKS5) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks5: network %s is not supported", network)
+	}
+	if s.ResolveLocally {
+		var err error
+		if address, err = resolve(ctx, network, address); err != nil {
+			return nil, err
+		}
+	}
+	conn, _, err := s.request(ctx, socksCmdConnect, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenPacket asks the proxy to relay UDP datagrams (UDP ASSOCIATE). The
+// association lasts until the returned connection is closed.
+func (s *SOCKS5) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	ctrl, relay, err := s.request(ctx, socksCmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	// Servers commonly answer with an unspecified address, meaning the
+	// relay lives on the proxy host
+	if relay.IP.IsUnspecified() {
+		if host, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP = host.IP
+		}
+	}
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	pc := &socksPacketConn{UDPConn: udp, ctrl: ctrl, relay: relay}
+	// The association ends when the proxy closes the control connection
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		pc.Close()
+	}()
+	return pc, nil
+}
+
+// resolve replaces the host name in address by its first IP address of
+// the family of network
+func resolve(ctx context.Context, network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+	family := "ip"
+	switch network {
+	case "tcp4":
+		family = "ip4"
+	case "tcp6":
+		family = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, family, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// request connects to the proxy, authenticates and sends a command. It
+// returns the control connection and the address the server bound.
+func (s *SOCKS5) request(ctx context.Context, cmd byte, address string) (net.Conn, *net.UDPAddr, error) {
+	conn, err := forwardOrDirect(s.Forward).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	bound, err := s.handshake(conn, cmd, address)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, bound, nil
+}
+
+func (s *SOCKS5) handshake(conn net.Conn, cmd byte, address string) (*net.UDPAddr, error) {
+	methods := []byte{socksAuthNone}
+	if s.Username != "" {
+		methods = append(methods, socksAuthPassword)
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != socksVersion {
+		return nil, fmt.Errorf("socks5: unexpected version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := s.authenticate(conn); err != nil {
+			return nil, err
+		}
+	case socksNoAcceptable:
+		return nil, errors.New("socks5: no acceptable authentication method")
+	default:
+		return nil, fmt.Errorf("socks5: unsupported authentication method %d", reply[1])
+	}
+
+	addr, err := appendSocksAddr([]byte{socksVersion, cmd, 0}, address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(addr); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[1] != 0 {
+		return nil, fmt.Errorf("socks5: request failed: %s", socksReplyText(header[1]))
+	}
+	return readSocksAddr(conn)
+}
+
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+	req := []byte{1, byte(len(s.Username))}
+	req = append(req, s.Username...)
+	req = append(req, byte(len(s.Password)))
+	req = append(req, s.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("socks5: authentication failed")
+	}
+	return nil
+}
+
+// appendSocksAddr appends address as ATYP, DST.ADDR and DST.PORT
+func appendSocksAddr(buf []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(buf, socksAtypIPv4)
+			buf = append(buf, ip4...)
+		} else {
+			buf = append(buf, socksAtypIPv6)
+			buf = append(buf, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5: host name %q too long", host)
+		}
+		buf = append(buf, socksAtypDomain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// readSocksAddr reads ATYP, BND.ADDR and BND.PORT. Domain names are
+// resolved, the relay has to be reached by IP anyway.
+func readSocksAddr(r io.Reader) (*net.UDPAddr, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return nil, err
+	}
+	var ip net.IP
+	switch atyp[0] {
+	case socksAtypIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case socksAtypIPv6:
+		ip = make(net.IP, net.IPv6len)
+	case socksAtypDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return nil, err
+		}
+		host := make([]byte, n[0]+2)
+		if _, err := io.ReadFull(r, host); err != nil {
+			return nil, err
+		}
+		return net.ResolveUDPAddr("udp", net.JoinHostPort(string(host[:n[0]]),
+			strconv.Itoa(int(binary.BigEndian.Uint16(host[n[0]:])))))
+	default:
+		return nil, fmt.Errorf("socks5: unknown address type %d", atyp[0])
+	}
+	buf := make([]byte, len(ip)+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	copy(ip, buf)
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(buf[len(ip):]))}, nil
+}
+
+func socksReplyText(code byte) string {
+	switch code {
+	case 1:
+		return "general server failure"
+	case 2:
+		return "connection not allowed by ruleset"
+	case 3:
+		return "network unreachable"
+	case 4:
+		return "host unreachable"
+	case 5:
+		return "connection refused"
+	case 6:
+		return "TTL expired"
+	case 7:
+		return "command not supported"
+	case 8:
+		return "address type not supported"
+	default:
+		return fmt.Sprintf("unknown error %d", code)
+	}
+}
+
+// socksPacketConn wraps datagrams in the SOCKS5 UDP request header and
+// sends them through the relay
+type socksPacketConn struct {
+	*net.UDPConn
+	ctrl  net.Conn
+	relay *net.UDPAddr
+
+	closeOnce sync.Once
+}
+
+func (c *socksPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf, err := appendSocksAddr([]byte{0, 0, 0}, addr.String())
+	if err != nil {
+		return 0, err
+	}
+	buf = append(buf, b...)
+	if _, err := c.UDPConn.WriteTo(buf, c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socksPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+262) // room for the largest header
+	for {
+		n, from, err := c.UDPConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		// Drop anything that does not come from the relay, and fragments,
+		// which we do not reassemble
+		if udpFrom, ok := from.(*net.UDPAddr); !ok || !udpFrom.IP.Equal(c.relay.IP) || udpFrom.Port != c.relay.Port {
+			continue
+		}
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := &sliceReader{buf: buf[3:n]}
+		src, err := readSocksAddr(r)
+		if err != nil {
+			continue
+		}
+		return copy(b, r.buf), src, nil
+	}
+}
+
+func (c *socksPacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.ctrl.Close()
+		err = c.UDPConn.Close()
+	})
+	return err
+}
+
+type sliceReader struct {
+	buf []byte
+}
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSOCKS5 is a minimal SOCKS5 server supporting CONNECT and UDP
+// ASSOCIATE, with optional password authentication
+type fakeSOCKS5 struct {
+	ln       net.Listener
+	username string
+	password string
+	// domains counts the requests that named their destination
+	domains atomic.Int32
+}
+
+func newFakeSOCKS5(t *testing.T, username, password string) *fakeSOCKS5 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSOCKS5{ln: ln, username: username, password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSOCKS5) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	io.ReadFull(conn, methods)
+	if s.username == "" {
+		conn.Write([]byte{5, socksAuthNone})
+	} else {
+		conn.Write([]byte{5, socksAuthPassword})
+		buf := make([]byte, 2)
+		io.ReadFull(conn, buf)
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != s.username || string(pass) != s.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 3)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(conn, atyp); err != nil {
+		return
+	}
+	if atyp[0] == socksAtypDomain {
+		s.domains.Add(1)
+	}
+	dst, err := readSocksAddr(io.MultiReader(bytes.NewReader(atyp), conn))
+	if err != nil {
+		return
+	}
+
+	switch req[1] {
+	case socksCmdConnect:
+		target, err := net.Dial("tcp", dst.String())
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte{5, 0, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	case socksCmdUDPAssociate:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		reply := []byte{5, 0, 0, socksAtypIPv4, 0, 0, 0, 0}
+		conn.Write(binary.BigEndian.AppendUint16(reply, uint16(relay.LocalAddr().(*net.UDPAddr).Port)))
+		go s.relay(relay)
+		io.Copy(io.Discard, conn)
+	default:
+		conn.Write([]byte{5, 7, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// relay forwards datagrams between the client and their destinations
+func (s *fakeSOCKS5) relay(relay *net.UDPConn) {
+	buf := make([]byte, 65536)
+	var client net.Addr
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			r := &sliceReader{buf: buf[3:n]}
+			dst, err := readSocksAddr(r)
+			if err != nil {
+				continue
+			}
+			relay.WriteTo(r.buf, dst)
+			continue
+		}
+		packet, _ := appendSocksAddr([]byte{0, 0, 0}, from.String())
+		relay.WriteTo(append(packet, buf[:n]...), client)
+	}
+}
+
+func newEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	echo := newEchoServer(t)
+	tests := map[string]struct {
+		username string
+		password string
+		fails    bool
+	}{
+		"no authentication": {},
+		"correct password":  {username: "user", password: "secret"},
+		"wrong password":    {username: "user", password: "wrong", fails: true},
+	}
+
+	for name, test := range tests {
+		server := newFakeSOCKS5(t, "", "")
+		if test.username != "" {
+			server = newFakeSOCKS5(t, "user", "secret")
+		}
+		d := &SOCKS5{Addr: server.ln.Addr().String(), Username: test.username, Password: test.password}
+		conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+		if test.fails {
+			assert.NotNil(t, err, name)
+			continue
+		}
+		require.Nil(t, err, name)
+		_, err = conn.Write([]byte("ping"))
+		require.Nil(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.Nil(t, err)
+		assert.Equal(t, "ping", string(buf), name)
+		conn.Close()
+	}
+}
+
+func TestSOCKS5ResolveLocally(t *testing.T) {
+	echo := newEchoServer(t)
+	_, port, err := net.SplitHostPort(echo.Addr().String())
+	require.Nil(t, err)
+	address := net.JoinHostPort("localhost", port)
+
+	for _, local := range []bool{false, true} {
+		server := newFakeSOCKS5(t, "", "")
+		d := &SOCKS5{Addr: server.ln.Addr().String(), ResolveLocally: local}
+		conn, err := d.DialContext(context.Background(), "tcp4", address)
+		require.Nil(t, err)
+		conn.Close()
+		if local {
+			assert.Equal(t, int32(0), server.domains.Load())
+		} else {
+			assert.Equal(t, int32(1), server.domains.Load())
+		}
+	}
+}
+
+func TestSOCKS5ConnectRefused(t *testing.T) {
+	server := newFakeSOCKS5(t, "", "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	d := &SOCKS5{Addr: server.ln.Addr().String()}
+	_, err = d.DialContext(context.Background(), "tcp", addr)
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestSOCKS5UDPAssociate(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], from)
+		}
+	}()
+
+	server := newFakeSOCKS5(t, "", "")
+	d := &SOCKS5{Addr: server.ln.Addr().String()}
+	pc, err := d.ListenPacket(context.Background())
+	require.Nil(t, err)
+	defer pc.Close()
+
+	_, err = pc.WriteTo([]byte("announce"), echo.LocalAddr())
+	require.Nil(t, err)
+	buf := make([]byte, 100)
+	n, from, err := pc.ReadFrom(buf)
+	require.Nil(t, err)
+	assert.Equal(t, "announce", string(buf[:n]))
+	assert.Equal(t, echo.LocalAddr().String(), from.String())
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that hands out bytes at a fixed rate.
+// A limit of 0 means unlimited. The limit can be changed while the
+// Limiter is in use, and a nil *Limiter never blocks.
+type Limiter struct {
+	mu     sync.Mutex
+	limit  int // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter allowing limit bytes per second
+func New(limit int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// Limit returns the current rate in bytes per second
+func (l *Limiter) Limit() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the rate in bytes per second. Waiters pick up the new
+// rate on their next call to WaitN.
+func (l *Limiter) SetLimit(limit int) {
+	if l == nil {
+		return
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.limit = limit
+	if l.tokens > float64(limit) {
+		l.tokens = float64(limit)
+	}
+}
+
+// advance refills the bucket up to one second worth of tokens
+func (l *Limiter) advance(now time.Time) {
+	if !l.last.IsZero() && l.limit > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+	}
+	l.last = now
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller
+// has to wait before they are actually available
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit == 0 {
+		return 0
+	}
+	l.advance(time.Now())
+	// The bucket is allowed to go into debt so that a single call may ask
+	// for more than one second worth of bytes
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+}
+
+// WaitN blocks until n bytes may be transferred
+func (l *Limiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitNUnlimited(t *testing.T) {
+	tests := map[string]*Limiter{
+		"nil limiter":    nil,
+		"zero limit":     New(0),
+		"negative limit": New(-1),
+	}
+	for name, l := range tests {
+		start := time.Now()
+		l.WaitN(1 << 30)
+		assert.Less(t, time.Since(start), 50*time.Millisecond, name)
+	}
+}
+
+func TestWaitNThrottles(t *testing.T) {
+	l := New(1000)
+	start := time.Now()
+	// The first second worth of tokens is not available yet, so asking
+	// for 200 bytes twice has to take about 400ms
+	l.WaitN(200)
+	l.WaitN(200)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 350*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestSetLimit(t *testing.T) {
+	l := New(10)
+	assert.Equal(t, 10, l.Limit())
+	l.SetLimit(0)
+	assert.Equal(t, 0, l.Limit())
+
+	start := time.Now()
+	l.WaitN(1 << 20)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	var nilLimiter *Limiter
+	assert.Equal(t, 0, nilLimiter.Limit())
+	nilLimiter.SetLimit(5)
+	assert.Equal(t, 0, nilLimiter.Limit())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/torrentfile"
+)
+
+// serve downloads a torrent and serves its files over HTTP meanwhile:
+//
+//	go-bittorrent-client serve [flags] file.torrent outdir
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags] file.torrent outdir\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	httpAddr := fs.String("http", "localhost:8080", "serve the torrent's files on http://ADDR/")
+	listenAddr := fs.String("listen", ":6881", "listen for peers on ADDR")
+	flags := addCommonFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	tf, err := torrentfile.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := runServe(ctx, &tf, fs.Arg(1), *listenAddr, *httpAddr, flags.options()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServe serves tf from a session until ctx is done. The session is
+// closed before it returns, also on errors.
+func runServe(ctx context.Context, tf *torrentfile.TorrentFile, outdir, listenAddr, httpAddr string, opts []torrentfile.Option) error {
+	s, err := torrentfile.NewSession(listenAddr, opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	h, err := s.Add(tf, outdir)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: httpAddr, Handler: h}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving %s on http://%s/\n", tf.Name, httpAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The BEP 47 attributes of a file
+const (
+	attrPadding    = "p"
+	attrExecutable = "x"
+	attrHidden     = "h"
+	attrSymlink    = "l"
+)
+
+// padding reports whether f only pads the next file to a piece boundary.
+// Padding files are zeros and never written to disk.
+func (f fileInfo) padding() bool {
+	return strings.Contains(f.Attr, attrPadding)
+}
+
+func (f fileInfo) executable() bool {
+	return strings.Contains(f.Attr, attrExecutable)
+}
+
+func (f fileInfo) hidden() bool {
+	return strings.Contains(f.Attr, attrHidden)
+}
+
+// symlink reports whether f is a link to SymlinkPath, which is relative
+// to the directory of the torrent
+func (f fileInfo) symlink() bool {
+	return strings.Contains(f.Attr, attrSymlink)
+}
+
+// file returns file i, a single-file torrent being a file named Name
+func (t *TorrentFile) file(i int) fileInfo {
+	if len(t.Files) == 0 {
+		return fileInfo{Attr: t.Attr, Length: t.Length, Path: []string{t.Name}, SHA1: t.SHA1}
+	}
+	return t.Files[i]
+}
+
+// isPadding reports whether file i is a padding file
+func (t *TorrentFile) isPadding(i int) bool {
+	return t.file(i).padding()
+}
+
+// checkFileHashes checks the files that have a sha1 hash against their
+// data in src, so that a mismatch fails the save before anything is
+// written
+func (t *TorrentFile) checkFileHashes(src io.ReaderAt, skipped func(int) bool) error {
+	for i := 0; i < t.numFiles(); i++ {
+		f := t.file(i)
+		if f.SHA1 == "" || skipped(i) || f.padding() || f.symlink() {
+			continue
+		}
+		begin, end := t.fileBounds(i)
+		hash := sha1.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(src, int64(begin), int64(end-begin))); err != nil {
+			return err
+		}
+		if !bytes.Equal(hash.Sum(nil), []byte(f.SHA1)) {
+			return fmt.Errorf("file %q does not match its sha1 hash", f.Path)
+		}
+	}
+	return nil
+}
+
+// saveFile writes f with data below root, the directory that holds the
+// files of the torrent. The paths of f must have been sanitized.
+func saveFile(root string, f fileInfo, data *io.SectionReader) error {
+	path := filepath.Join(root, filepath.Join(f.Path...))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	// A link left by an earlier download must not redirect the write,
+	// and a link replaces the file that was there
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() && (f.symlink() || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if f.symlink() {
+		return createSymlink(root, path, f.SymlinkPath)
+	}
+	if err := writeFile(path, io.NewSectionReader(data, 0, data.Size())); err != nil {
+		return err
+	}
+	if f.executable() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		// Whoever may read the file may execute it
+		mode := info.Mode().Perm()
+		if err := os.Chmod(path, mode|(mode&0o444)>>2); err != nil {
+			return err
+		}
+	}
+	if f.hidden() {
+		return hide(path)
+	}
+	return nil
+}
+
+// writeFile writes everything read from r to path
+func writeFile(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// createSymlink makes path a relative link to target below root
+func createSymlink(root, path string, target []string) error {
+	if len(target) == 0 {
+		return errors.New("symlink without a target")
+	}
+	rel, err := filepath.Rel(filepath.Dir(path), filepath.Join(root, filepath.Join(target...)))
+	if err != nil {
+		return err
+	}
+	return os.Symlink(rel, path)
+}
@@ -0,0 +1,103 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveToDiskAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks and exec bits need a Unix file system")
+	}
+	bin, data := []byte("#!/bin/sh\n"), []byte("data")
+	binHash := sha1.Sum(bin)
+	tf := TorrentFile{Name: "release", PieceLength: 16, Files: []fileInfo{
+		{Attr: "x", Length: len(bin), Path: []string{"run.sh"}, SHA1: string(binHash[:])},
+		{Attr: "p", Length: 6, Path: []string{".pad", "6"}},
+		{Attr: "l", Path: []string{"latest"}, SymlinkPath: []string{"sub", "data"}},
+		{Length: len(data), Path: []string{"sub", "data"}},
+	}}
+	buf := append(append(append([]byte{}, bin...), make([]byte, 6)...), data...)
+	tf.Length = len(buf)
+	dir := t.TempDir()
+	root := filepath.Join(dir, "release")
+
+	// A link left behind must not redirect the write
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.WriteFile(outside, nil, 0o644))
+	require.NoError(t, os.MkdirAll(root, 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "run.sh")))
+
+	require.NoError(t, tf.saveToDisk(bytes.NewReader(buf), dir, nil, slog.Default()))
+
+	info, err := os.Lstat(filepath.Join(root, "run.sh"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.NotZero(t, info.Mode().Perm()&0o100)
+	kept, err := os.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Empty(t, kept)
+
+	_, err = os.Stat(filepath.Join(root, ".pad"))
+	assert.True(t, os.IsNotExist(err))
+
+	target, err := os.Readlink(filepath.Join(root, "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("sub", "data"), target)
+	linked, err := os.ReadFile(filepath.Join(root, "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, data, linked)
+
+	// Saving again replaces the link instead of failing on it
+	require.NoError(t, tf.saveToDisk(bytes.NewReader(buf), dir, nil, slog.Default()))
+}
+
+func TestSaveToDiskSHA1Mismatch(t *testing.T) {
+	hash := sha1.Sum([]byte("other"))
+	tf := TorrentFile{Name: "a", Length: 8, PieceLength: 4, Files: []fileInfo{
+		{Length: 4, Path: []string{"e"}},
+		{Length: 4, Path: []string{"f"}, SHA1: string(hash[:])},
+	}}
+	dir := t.TempDir()
+	err := tf.saveToDisk(strings.NewReader("datadata"), dir, nil, slog.Default())
+	assert.ErrorContains(t, err, "sha1")
+	// The file before the mismatch is not written either
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSaveToDiskSingleExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec bits need a Unix file system")
+	}
+	tf := TorrentFile{Name: "tool", Length: 4, PieceLength: 4, Attr: "x"}
+	dir := t.TempDir()
+	require.NoError(t, tf.saveToDisk(strings.NewReader("data"), dir, nil, slog.Default()))
+	info, err := os.Stat(filepath.Join(dir, "tool"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode().Perm()&0o100)
+}
+
+func TestSymlinkPathSanitized(t *testing.T) {
+	tf := TorrentFile{Name: "a", Files: []fileInfo{
+		{Attr: "l", Path: []string{"link"}, SymlinkPath: []string{"..", "etc"}},
+	}}
+	assert.ErrorContains(t, tf.sanitizePaths(), "symlink path")
+
+	tf.Files[0].SymlinkPath = nil
+	assert.ErrorContains(t, tf.sanitizePaths(), "no target")
+
+	single := "d4:infod4:attr1:l6:lengthi0e4:name4:link12:piece lengthi4e6:pieces0:ee"
+	_, err := parse(strings.NewReader(single))
+	assert.ErrorContains(t, err, "single-file torrent is a symlink")
+}
@@ -0,0 +1,402 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+	"github.com/parkma99/go-bittorrent-client/merkle"
+)
+
+// DefaultCreatedBy is written as "created by" when CreateOptions has none
+const DefaultCreatedBy = "go-bittorrent-client"
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// targetPieces is roughly how many pieces an automatic piece length
+	// aims for
+	targetPieces = 1500
+)
+
+// CreateOptions describe the metainfo written by Create
+type CreateOptions struct {
+	// Announce is the tracker. It defaults to the first tracker of
+	// AnnounceList.
+	Announce string
+	// AnnounceList holds tiers of trackers (BEP 12)
+	AnnounceList [][]string
+	Comment      string
+	// CreatedBy defaults to DefaultCreatedBy
+	CreatedBy string
+	// CreationDate defaults to now, NoCreationDate leaves it out
+	CreationDate   time.Time
+	NoCreationDate bool
+	Private        bool
+	// URLList holds web seeds (BEP 19)
+	URLList []string
+	// PieceLength of zero picks a power of two from the total size
+	PieceLength int
+	// Name defaults to the base name of the path
+	Name string
+	// Hybrid adds a v2 file tree and piece layers (BEP 52), so that the
+	// torrent is in the v1 and the v2 swarm. Every file but the last is
+	// then followed by a padding file (BEP 47) up to the next piece
+	// boundary, and PieceLength must be a power of two of at least 16 KiB.
+	Hybrid bool
+}
+
+// createdFile is a file that goes into a new torrent
+type createdFile struct {
+	path   string
+	length int
+	// torrentPath is the path within the torrent
+	torrentPath []string
+	// padding files are zeros that are not read from disk
+	padding bool
+}
+
+// Create makes a torrent of the file or directory at root, writes its
+// metainfo to w and returns it
+func Create(w io.Writer, root string, opts CreateOptions) (TorrentFile, error) {
+	files, single, err := collectFiles(root)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	total := 0
+	for _, f := range files {
+		total += f.length
+	}
+	if total == 0 {
+		return TorrentFile{}, fmt.Errorf("%s holds no data", root)
+	}
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	}
+	if pieceLength < 0 {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", pieceLength)
+	}
+	layout := files
+	var trees []pieceTree
+	if opts.Hybrid {
+		if pieceLength < merkle.BlockSize || pieceLength != merkle.NextPowerOfTwo(pieceLength) {
+			return TorrentFile{}, fmt.Errorf("piece length %d of a hybrid torrent is not a power of two of at least %d", pieceLength, merkle.BlockSize)
+		}
+		layout = padFiles(files, pieceLength)
+		trees = pieceTrees(layout, pieceLength)
+	}
+	layoutLength := 0
+	for _, f := range layout {
+		layoutLength += f.length
+	}
+	pieces, piecesV2, err := hashPieces(layout, layoutLength, pieceLength, trees)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+
+	info := bencodeInfo{
+		Name:        opts.Name,
+		PieceLength: pieceLength,
+		Pieces:      string(pieces),
+	}
+	if info.Name == "" {
+		info.Name = filepath.Base(filepath.Clean(root))
+	}
+	if single {
+		info.Length = total
+	} else {
+		for _, f := range layout {
+			fi := fileInfo{Length: f.length, Path: f.torrentPath}
+			if f.padding {
+				fi.Attr = "p"
+			}
+			info.Files = append(info.Files, fi)
+		}
+	}
+	var pieceLayers map[string]string
+	if opts.Hybrid {
+		if single {
+			layout[0].torrentPath = []string{info.Name}
+		}
+		info.MetaVersion = 2
+		info.FileTree, pieceLayers = fileTreeV2(layout, pieceLength, piecesV2)
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+
+	bto := bencodeTorrent{
+		Announce:     opts.Announce,
+		AnnounceList: opts.AnnounceList,
+		Comment:      opts.Comment,
+		CreatedBy:    opts.CreatedBy,
+		Info:         info,
+		PieceLayers:  pieceLayers,
+		URLList:      opts.URLList,
+	}
+	if bto.Announce == "" && len(opts.AnnounceList) > 0 && len(opts.AnnounceList[0]) > 0 {
+		bto.Announce = opts.AnnounceList[0][0]
+	}
+	if bto.CreatedBy == "" {
+		bto.CreatedBy = DefaultCreatedBy
+	}
+	if !opts.NoCreationDate {
+		date := opts.CreationDate
+		if date.IsZero() {
+			date = time.Now()
+		}
+		bto.CreationDate = int(date.Unix())
+	}
+	var metainfo bytes.Buffer
+	if _, err := bencode.Marshal(&metainfo, bto); err != nil {
+		return TorrentFile{}, err
+	}
+	t, err := parse(bytes.NewReader(metainfo.Bytes()))
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	if _, err := w.Write(metainfo.Bytes()); err != nil {
+		return TorrentFile{}, err
+	}
+	return t, nil
+}
+
+// collectFiles lists the regular files below root in lexical order.
+// single is true when root is a file itself.
+func collectFiles(root string) (files []createdFile, single bool, err error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return []createdFile{{path: root, length: int(info.Size())}}, true, nil
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, createdFile{
+			path:        path,
+			length:      int(info.Size()),
+			torrentPath: strings.Split(filepath.ToSlash(rel), "/"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(files) == 0 {
+		return nil, false, fmt.Errorf("%s holds no files", root)
+	}
+	return files, false, nil
+}
+
+// choosePieceLength picks the smallest power of two that keeps the number
+// of pieces near targetPieces
+func choosePieceLength(total int) int {
+	pieceLength := minPieceLength
+	for total/pieceLength > targetPieces && pieceLength < maxPieceLength {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// padFiles returns files with a padding file after every file but the
+// last that does not end at a piece boundary
+func padFiles(files []createdFile, pieceLength int) []createdFile {
+	var layout []createdFile
+	offset := 0
+	for i, f := range files {
+		layout = append(layout, f)
+		offset += f.length
+		if n := pieceLength - offset%pieceLength; i < len(files)-1 && n != pieceLength {
+			layout = append(layout, createdFile{
+				length:      n,
+				torrentPath: []string{".pad", strconv.Itoa(n)},
+				padding:     true,
+			})
+			offset += n
+		}
+	}
+	return layout
+}
+
+// pieceTree is the part of a piece of a hybrid torrent that its merkle
+// tree covers: the data of the file the piece belongs to, without the
+// padding that follows it
+type pieceTree struct {
+	length int
+	// leaves is the width of the tree in blocks
+	leaves int
+}
+
+// pieceTrees returns the tree of every piece of layout, whose files all
+// start at piece boundaries
+func pieceTrees(layout []createdFile, pieceLength int) []pieceTree {
+	var trees []pieceTree
+	for _, f := range layout {
+		if f.padding {
+			continue
+		}
+		for begin := 0; begin < f.length; begin += pieceLength {
+			length := min(pieceLength, f.length-begin)
+			if f.length <= pieceLength {
+				// The tree of a file in one piece is only as wide as the file
+				blocks := (f.length + merkle.BlockSize - 1) / merkle.BlockSize
+				trees = append(trees, pieceTree{length, merkle.NextPowerOfTwo(blocks)})
+			} else {
+				trees = append(trees, pieceTree{length, pieceLength / merkle.BlockSize})
+			}
+		}
+	}
+	return trees
+}
+
+// fileTreeV2 returns the file tree of layout and the piece layers of the
+// files longer than a piece, from the merkle roots of the pieces
+func fileTreeV2(layout []createdFile, pieceLength int, pieces [][32]byte) (tree map[string]any, layers map[string]string) {
+	tree = make(map[string]any)
+	layers = make(map[string]string)
+	pad := merkle.PadHash(merkle.Log2(pieceLength / merkle.BlockSize))
+	offset := 0
+	for _, f := range layout {
+		first := offset / pieceLength
+		offset += f.length
+		if f.padding {
+			continue
+		}
+		attrs := map[string]any{"length": f.length}
+		if f.length > 0 {
+			layer := pieces[first : first+(f.length+pieceLength-1)/pieceLength]
+			root := layer[0]
+			if len(layer) > 1 {
+				root = merkle.Root(layer, merkle.NextPowerOfTwo(len(layer)), pad)
+				var b []byte
+				for _, hash := range layer {
+					b = append(b, hash[:]...)
+				}
+				layers[string(root[:])] = string(b)
+			}
+			attrs["pieces root"] = string(root[:])
+		}
+		dir := tree
+		for _, name := range f.torrentPath[:len(f.torrentPath)-1] {
+			sub, ok := dir[name].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+				dir[name] = sub
+			}
+			dir = sub
+		}
+		dir[f.torrentPath[len(f.torrentPath)-1]] = map[string]any{"": attrs}
+	}
+	return tree, layers
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of all pieces, hashed
+// by one worker per CPU. With trees, the same pass returns the merkle
+// root of every piece of a hybrid torrent.
+func hashPieces(files []createdFile, total, pieceLength int, trees []pieceTree) ([]byte, [][32]byte, error) {
+	numPieces := (total + pieceLength - 1) / pieceLength
+	hashes := make([]byte, numPieces*sha1.Size)
+	var hashesV2 [][32]byte
+	if trees != nil {
+		hashesV2 = make([][32]byte, numPieces)
+	}
+	indices := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for index := range indices {
+				begin := index * pieceLength
+				end := min(begin+pieceLength, total)
+				if err := readSpan(files, begin, buf[:end-begin]); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				hash := sha1.Sum(buf[:end-begin])
+				copy(hashes[index*sha1.Size:], hash[:])
+				if trees != nil {
+					hashesV2[index] = merkle.DataRoot(buf[:trees[index].length], trees[index].leaves)
+				}
+			}
+		}()
+	}
+	for index := 0; index < numPieces; index++ {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	return hashes, hashesV2, firstErr
+}
+
+// readSpan fills buf with the bytes starting at offset of the files laid
+// end to end
+func readSpan(files []createdFile, offset int, buf []byte) error {
+	fileBegin := 0
+	read := 0
+	for _, f := range files {
+		fileEnd := fileBegin + f.length
+		if read < len(buf) && offset+read < fileEnd {
+			part := buf[read:min(len(buf), fileEnd-offset)]
+			if f.padding {
+				clear(part)
+				read += len(part)
+			} else {
+				n, err := readFileAt(f.path, part, int64(offset+read-fileBegin))
+				read += n
+				if err != nil {
+					return err
+				}
+			}
+		}
+		fileBegin = fileEnd
+	}
+	if read != len(buf) {
+		return errors.New("files changed while hashing")
+	}
+	return nil
+}
+
+func readFileAt(path string, buf []byte, off int64) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	n, err := file.ReadAt(buf, off)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%s got shorter while hashing", path)
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateMultiFile(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "album")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "disc 2"), 0o755))
+	a := bytes.Repeat([]byte("a"), 40000)
+	b := bytes.Repeat([]byte("b"), 10)
+	c := bytes.Repeat([]byte("c"), 30000)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.flac"), a, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "b.txt"), b, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "disc 2", "c.flac"), c, 0o644))
+
+	out := filepath.Join(t.TempDir(), "album.torrent")
+	f, err := os.Create(out)
+	require.NoError(t, err)
+	created, err := Create(f, root, CreateOptions{
+		AnnounceList: [][]string{{"http://tracker.example/announce"}, {"http://backup.example/announce"}},
+		Comment:      "test album",
+		CreationDate: time.Unix(1700000000, 0),
+		Private:      true,
+		URLList:      []string{"http://mirror.example/"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	tf, err := Open(out)
+	require.NoError(t, err)
+	assert.Equal(t, created, tf)
+	assert.Equal(t, "http://tracker.example/announce", tf.Announce)
+	assert.Equal(t, "album", tf.Name)
+	assert.Equal(t, minPieceLength, tf.PieceLength)
+	assert.Equal(t, []string{"http://mirror.example/"}, tf.URLList)
+	assert.Equal(t, []fileInfo{
+		{Length: len(a), Path: []string{"a.flac"}},
+		{Length: len(b), Path: []string{"b.txt"}},
+		{Length: len(c), Path: []string{"disc 2", "c.flac"}},
+	}, tf.Files)
+
+	data := append(append(append([]byte{}, a...), b...), c...)
+	require.Equal(t, len(data), tf.Length)
+	require.Len(t, tf.PieceHashes, 5)
+	for i, hash := range tf.PieceHashes {
+		begin, end := tf.calculateBoundsForPiece(i)
+		assert.Equal(t, sha1.Sum(data[begin:end]), hash, "piece %d", i)
+	}
+
+	raw, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "7:privatei1e")
+	assert.Contains(t, string(raw), "13:creation datei1700000000e")
+	assert.Contains(t, string(raw), "10:created by20:"+DefaultCreatedBy)
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	data := bytes.Repeat([]byte("0123456789"), 5000)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	var buf bytes.Buffer
+	tf, err := Create(&buf, path, CreateOptions{
+		Announce:       "http://tracker.example/announce",
+		PieceLength:    1 << 15,
+		NoCreationDate: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "data.bin", tf.Name)
+	assert.Equal(t, len(data), tf.Length)
+	assert.Nil(t, tf.Files)
+	require.Len(t, tf.PieceHashes, 2)
+	assert.Equal(t, sha1.Sum(data[1<<15:]), tf.PieceHashes[1])
+	assert.NotContains(t, buf.String(), "creation date")
+	assert.NotContains(t, buf.String(), "private")
+}
+
+func TestCreateEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := Create(&buf, t.TempDir(), CreateOptions{})
+	assert.Error(t, err)
+}
+
+func TestChoosePieceLength(t *testing.T) {
+	assert.Equal(t, minPieceLength, choosePieceLength(1))
+	assert.Equal(t, 1<<18, choosePieceLength(300<<20))
+	assert.Equal(t, maxPieceLength, choosePieceLength(1<<40))
+}
+
+func TestCreateHybrid(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "mixed")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+	a := bytes.Repeat([]byte("a"), 40000)
+	b := bytes.Repeat([]byte("b"), 10)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.bin"), a, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "b.txt"), b, 0o644))
+
+	var buf bytes.Buffer
+	tf, err := Create(&buf, root, CreateOptions{Hybrid: true, PieceLength: minPieceLength})
+	require.NoError(t, err)
+	assert.True(t, tf.hybrid())
+	assert.Equal(t, []fileInfo{
+		{Length: len(a), Path: []string{"a.bin"}},
+		{Attr: "p", Length: 3*minPieceLength - len(a), Path: []string{".pad", "9152"}},
+		{Length: len(b), Path: []string{"sub", "b.txt"}},
+	}, tf.Files)
+	require.Len(t, tf.FilesV2, 2)
+	assert.Len(t, tf.FilesV2[0].PieceLayer, 3)
+	assert.Len(t, tf.PieceHashes, 4)
+	hashes := tf.infoHashes()
+	require.Len(t, hashes, 2)
+	assert.NotEqual(t, hashes[0], hashes[1])
+
+	_, err = Create(&buf, root, CreateOptions{Hybrid: true, PieceLength: 3 * minPieceLength})
+	assert.ErrorContains(t, err, "power of two")
+
+	// The merkle roots of the pieces of a single file come from the same
+	// pass as their SHA-1 hashes
+	buf.Reset()
+	tf, err = Create(&buf, filepath.Join(root, "a.bin"), CreateOptions{Hybrid: true, PieceLength: minPieceLength})
+	require.NoError(t, err)
+	opened, err := parse(&buf)
+	require.NoError(t, err)
+	assert.True(t, opened.hybrid())
+	require.Len(t, opened.FilesV2, 1)
+	assert.Len(t, opened.FilesV2[0].PieceLayer, 3)
+	assert.Equal(t, tf.InfoHashV2, opened.InfoHashV2)
+}
@@ -0,0 +1,205 @@
+package torrentfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+)
+
+// numFiles returns the number of entries in Files, a single-file torrent
+// counts as one
+func (t *TorrentFile) numFiles() int {
+	if len(t.Files) == 0 {
+		return 1
+	}
+	return len(t.Files)
+}
+
+// fileBounds returns the byte range of file i within the torrent
+func (t *TorrentFile) fileBounds(i int) (begin, end int) {
+	if len(t.Files) == 0 {
+		return 0, t.Length
+	}
+	begin = t.fileOffsets()[i]
+	return begin, begin + t.Files[i].Length
+}
+
+// fileOffsets returns where every file begins within the torrent. They are
+// computed once by Open, torrents built otherwise compute them on every
+// call.
+func (t *TorrentFile) fileOffsets() []int {
+	if len(t.offsets) == t.numFiles() {
+		return t.offsets
+	}
+	offsets := make([]int, t.numFiles())
+	for i := 1; i < len(t.Files); i++ {
+		offsets[i] = t.alignFile(offsets[i-1] + t.Files[i-1].Length)
+	}
+	return offsets
+}
+
+// filePriorities returns the priority of every file, nil when every file
+// is wanted with PriorityNormal
+func (o *options) filePriorities(t *TorrentFile) ([]client.Priority, error) {
+	if o.priorities == nil && o.files == nil {
+		return nil, nil
+	}
+	prios := make([]client.Priority, t.numFiles())
+	for i := range prios {
+		if o.files != nil {
+			prios[i] = client.PrioritySkip
+		}
+		if i < len(o.priorities) {
+			prios[i] = o.priorities[i]
+		}
+	}
+	if len(o.priorities) > len(prios) {
+		return nil, fmt.Errorf("got %d file priorities for %d files", len(o.priorities), len(prios))
+	}
+	for _, i := range o.files {
+		if i < 0 || i >= len(prios) {
+			return nil, fmt.Errorf("file index %d out of range", i)
+		}
+		if prios[i] == client.PrioritySkip {
+			prios[i] = client.PriorityNormal
+		}
+	}
+	return prios, nil
+}
+
+// piecePriorities gives each piece the highest priority of the files it
+// overlaps. A piece is only skipped if every file it overlaps is skipped.
+func (t *TorrentFile) piecePriorities(filePrios []client.Priority) []client.Priority {
+	if filePrios == nil {
+		return nil
+	}
+	prios := make([]client.Priority, t.numPieces())
+	for i := range prios {
+		prios[i] = client.PrioritySkip
+	}
+	for i, fp := range filePrios {
+		begin, end := t.fileBounds(i)
+		if begin == end {
+			continue
+		}
+		for index := begin / t.PieceLength; index <= (end-1)/t.PieceLength; index++ {
+			if fp > prios[index] {
+				prios[index] = fp
+			}
+		}
+	}
+	return prios
+}
+
+// partFilePath is where the pieces shared between wanted and skipped files
+// are kept, so that the skipped files do not have to be created
+func (t *TorrentFile) partFilePath(path string) string {
+	return filepath.Join(path, "."+t.Name+".parts")
+}
+
+// writePartFile saves every downloaded piece that overlaps a skipped file
+// to the part file, as a 4 byte piece index followed by the piece. Without
+// such pieces the part file is removed.
+func (t *TorrentFile) writePartFile(src io.ReaderAt, path string, filePrios []client.Priority) error {
+	piecePrios := t.piecePriorities(filePrios)
+	var pieces []int
+	seen := make(map[int]bool)
+	for i, fp := range filePrios {
+		begin, end := t.fileBounds(i)
+		if fp != client.PrioritySkip || begin == end {
+			continue
+		}
+		for _, index := range []int{begin / t.PieceLength, (end - 1) / t.PieceLength} {
+			if piecePrios[index] != client.PrioritySkip && !seen[index] {
+				seen[index] = true
+				pieces = append(pieces, index)
+			}
+		}
+	}
+	partPath := t.partFilePath(path)
+	if len(pieces) == 0 {
+		err := os.Remove(partPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, index := range pieces {
+		begin, end := t.calculateBoundsForPiece(index)
+		if err := binary.Write(w, binary.BigEndian, uint32(index)); err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(src, int64(begin), int64(end-begin))); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readPartFile returns the pieces saved by writePartFile. A missing part
+// file holds no pieces.
+func (t *TorrentFile) readPartFile(path string) (map[int][]byte, error) {
+	file, err := os.Open(t.partFilePath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	pieces := make(map[int][]byte)
+	for {
+		var index uint32
+		err := binary.Read(r, binary.BigEndian, &index)
+		if err == io.EOF {
+			return pieces, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int(index) >= t.numPieces() {
+			return nil, fmt.Errorf("part file holds piece %d of %d", index, t.numPieces())
+		}
+		begin, end := t.calculateBoundsForPiece(int(index))
+		piece := make([]byte, end-begin)
+		if _, err := io.ReadFull(r, piece); err != nil {
+			return nil, err
+		}
+		pieces[int(index)] = piece
+	}
+}
+
+func (t *TorrentFile) calculateBoundsForPiece(index int) (begin int, end int) {
+	begin = index * t.PieceLength
+	end = min(begin+t.PieceLength, t.bufferLength())
+	if t.v2Only() {
+		// The last piece of a file ends with the file, which is the last
+		// one starting at or before the piece
+		offsets := t.fileOffsets()
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > begin }) - 1
+		if fileBegin, fileEnd := t.fileBounds(i); fileBegin <= begin && begin < fileEnd {
+			end = min(end, fileEnd)
+		}
+	}
+	return begin, end
+}
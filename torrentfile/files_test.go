@@ -0,0 +1,143 @@
+package torrentfile
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPiecePriorities(t *testing.T) {
+	// Pieces of 10 bytes: a covers pieces 0-1, b pieces 1-2, c piece 2
+	tf := &TorrentFile{
+		PieceHashes: make([][20]byte, 3),
+		PieceLength: 10,
+		Length:      30,
+		Files: []fileInfo{
+			{Length: 15, Path: []string{"a"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 10, Path: []string{"b"}},
+			{Length: 5, Path: []string{"c"}},
+		},
+	}
+	tests := map[string]struct {
+		opts     []Option
+		files    []client.Priority
+		pieces   []client.Priority
+		hasError bool
+	}{
+		"default": {},
+		"only b": {
+			opts:   []Option{WithFiles(2)},
+			files:  []client.Priority{client.PrioritySkip, client.PrioritySkip, client.PriorityNormal, client.PrioritySkip},
+			pieces: []client.Priority{client.PrioritySkip, client.PriorityNormal, client.PriorityNormal},
+		},
+		"priorities": {
+			opts:   []Option{WithFilePriorities(client.PriorityLow, client.PrioritySkip, client.PriorityHigh)},
+			files:  []client.Priority{client.PriorityLow, client.PrioritySkip, client.PriorityHigh, client.PriorityNormal},
+			pieces: []client.Priority{client.PriorityLow, client.PriorityHigh, client.PriorityHigh},
+		},
+		"bad index": {
+			opts:     []Option{WithFiles(4)},
+			hasError: true,
+		},
+	}
+	for name, test := range tests {
+		files, err := newOptions(test.opts).filePriorities(tf)
+		if test.hasError {
+			assert.NotNil(t, err, name)
+			continue
+		}
+		require.Nil(t, err, name)
+		assert.Equal(t, test.files, files, name)
+		assert.Equal(t, test.pieces, tf.piecePriorities(files), name)
+	}
+}
+
+func TestSessionSelectedFiles(t *testing.T) {
+	tracker := newTestTracker(t)
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	tf := newTestTorrentFile(tracker.URL, "dataset", data, 16384)
+	tf.Files = []fileInfo{
+		{Length: 10000, Path: []string{"one.bin"}},
+		{Length: 20000, Path: []string{"sub", "two.bin"}},
+		{Length: 10000, Path: []string{"three.bin"}},
+	}
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Close()
+	dir := t.TempDir()
+	h, err := s.Add(tf, dir, WithFiles(1))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			seedTo(s.Port(), tf.InfoHash, data, tf.PieceLength)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	state, err := h.Wait(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, StateCompleted, state)
+
+	written, err := os.ReadFile(filepath.Join(dir, "dataset", "sub", "two.bin"))
+	require.Nil(t, err)
+	assert.Equal(t, data[10000:30000], written)
+	assert.NoFileExists(t, filepath.Join(dir, "dataset", "one.bin"))
+	assert.NoFileExists(t, filepath.Join(dir, "dataset", "three.bin"))
+
+	// Both pieces shared with the skipped files end up in the part file
+	pieces, err := tf.readPartFile(dir)
+	require.Nil(t, err)
+	assert.Len(t, pieces, 2)
+	assert.Equal(t, data[0:16384], pieces[0])
+	assert.Equal(t, data[16384:32768], pieces[1])
+}
+
+func TestSessionFileReader(t *testing.T) {
+	tracker := newTestTracker(t)
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 5)
+	}
+	tf := newTestTorrentFile(tracker.URL, "stream", data, 16384)
+	tf.Files = []fileInfo{
+		{Length: 25000, Path: []string{"a.bin"}},
+		{Length: 15000, Path: []string{"b.bin"}},
+	}
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Close()
+	h, err := s.Add(tf, t.TempDir())
+	require.Nil(t, err)
+	_, err = h.NewFileReader(context.Background(), 2)
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := h.NewFileReader(ctx, 1)
+	require.Nil(t, err)
+	defer r.Close()
+	go func() {
+		for ctx.Err() == nil {
+			seedTo(s.Port(), tf.InfoHash, data, tf.PieceLength)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	streamed, err := io.ReadAll(r)
+	require.Nil(t, err)
+	assert.Equal(t, data[25000:], streamed)
+}
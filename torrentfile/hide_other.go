@@ -0,0 +1,8 @@
+//go:build !windows
+
+package torrentfile
+
+// hide does nothing, a file is hidden by a name starting with a dot
+func hide(path string) error {
+	return nil
+}
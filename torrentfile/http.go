@@ -0,0 +1,132 @@
+package torrentfile
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+)
+
+// maxRangeReadahead caps the readahead a range request asks for
+const maxRangeReadahead = 64 << 20
+
+// ServeHTTP serves the files of the torrent while it downloads. The root
+// lists the files, every file is served at its path within the torrent.
+// Byte ranges are supported, and the pieces of the requested range are
+// downloaded before all others.
+func (h *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		h.serveIndex(w)
+		return
+	}
+	i := h.tf.fileIndex(name)
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if h.d.files != nil && h.d.files[i] == client.PrioritySkip {
+		http.Error(w, "file is not being downloaded", http.StatusNotFound)
+		return
+	}
+
+	reader, err := h.NewFileReader(r.Context(), i)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	begin, end := h.tf.fileBounds(i)
+	if n := rangeLength(r.Header.Get("Range"), int64(end-begin)); n > 0 {
+		reader.SetReadahead(n)
+	}
+	http.ServeContent(w, r, path.Base(name), time.Time{}, reader)
+}
+
+func (h *Handle) serveIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!doctype html>\n<title>%s</title>\n<ul>\n", html.EscapeString(h.tf.Name))
+	for i := 0; i < h.tf.numFiles(); i++ {
+		if h.tf.isPadding(i) {
+			continue
+		}
+		name := h.tf.filePath(i)
+		var escaped []string
+		for _, part := range strings.Split(name, "/") {
+			escaped = append(escaped, url.PathEscape(part))
+		}
+		fmt.Fprintf(w, "<li><a href=\"/%s\">%s</a></li>\n", strings.Join(escaped, "/"), html.EscapeString(name))
+	}
+	fmt.Fprintln(w, "</ul>")
+}
+
+// filePath returns the slash separated path of file i within the torrent
+func (t *TorrentFile) filePath(i int) string {
+	if len(t.Files) == 0 {
+		return t.Name
+	}
+	return strings.Join(t.Files[i].Path, "/")
+}
+
+// fileIndex returns the index of the file at the slash separated name,
+// or -1
+func (t *TorrentFile) fileIndex(name string) int {
+	for i := 0; i < t.numFiles(); i++ {
+		if t.filePath(i) == name && !t.isPadding(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rangeLength returns the length of a single "bytes=start-end" range in a
+// file of size bytes, or 0 when header is something else
+func rangeLength(header string, size int64) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0
+	}
+	var n int64
+	if startStr == "" {
+		// A suffix range, the last endStr bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			return 0
+		}
+		n = suffix
+	} else {
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil {
+			return 0
+		}
+		end := size - 1
+		if endStr != "" {
+			if end, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+				return 0
+			}
+		}
+		n = end - start + 1
+	}
+	if n <= 0 {
+		return 0
+	}
+	if n > maxRangeReadahead {
+		n = maxRangeReadahead
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package torrentfile
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleServeHTTP(t *testing.T) {
+	tracker := newTestTracker(t)
+	data := make([]byte, 60000)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	tf := newTestTorrentFile(tracker.URL, "served", data, 16384)
+	tf.Files = []fileInfo{
+		{Length: 10000, Path: []string{"a.txt"}},
+		{Length: 50000, Path: []string{"video", "b c.bin"}},
+	}
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Close()
+	h, err := s.Add(tf, t.TempDir())
+	require.Nil(t, err)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			seedTo(s.Port(), tf.InfoHash, data, tf.PieceLength)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	get := func(path, rangeHeader string) (*http.Response, []byte) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		require.Nil(t, err)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp, body
+	}
+
+	resp, body := get("/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `<a href="/video/b%20c.bin">video/b c.bin</a>`)
+
+	resp, body = get("/video/b%20c.bin", "bytes=30000-30099")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 30000-30099/50000", resp.Header.Get("Content-Range"))
+	assert.Equal(t, data[40000:40100], body)
+
+	resp, body = get("/a.txt", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, data[:10000], body)
+
+	resp, _ = get("/missing", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRangeLength(t *testing.T) {
+	tests := map[string]int64{
+		"bytes=0-99":       100,
+		"bytes=500-":       500,
+		"bytes=-10":        10,
+		"bytes=0-1,5-6":    0,
+		"items=0-1":        0,
+		"bytes=10-5":       0,
+		"bytes=0-99999999": maxRangeReadahead,
+	}
+	for header, expected := range tests {
+		assert.Equal(t, expected, rangeLength(header, 1000), header)
+	}
+}
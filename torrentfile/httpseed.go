@@ -0,0 +1,100 @@
+package torrentfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+)
+
+// defaultRetryAfter is how long to wait for a busy HTTP seed that did not
+// say how long
+const defaultRetryAfter = 30 * time.Second
+
+// httpSeed fetches pieces from a BEP 17 HTTP seed, a script answering
+// ?info_hash=...&piece=...&ranges=... with the bytes of a piece
+type httpSeed struct {
+	url      string
+	infoHash [20]byte
+	client   *http.Client
+	// timeout bounds every request, see seedTimeout
+	timeout time.Duration
+}
+
+func newHTTPSeed(u string, infoHash [20]byte, client *http.Client) *httpSeed {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSeed{url: u, infoHash: infoHash, client: client, timeout: seedRequestTimeout}
+}
+
+func (hs *httpSeed) String() string {
+	return hs.url
+}
+
+func (hs *httpSeed) pieceURL(index, length int) (string, error) {
+	base, err := url.Parse(hs.url)
+	if err != nil {
+		return "", err
+	}
+	params := base.Query()
+	params.Set("info_hash", string(hs.infoHash[:]))
+	params.Set("piece", strconv.Itoa(index))
+	// Ranges are inclusive and relative to the start of the piece
+	params.Set("ranges", fmt.Sprintf("0-%d", length-1))
+	base.RawQuery = params.Encode()
+	return base.String(), nil
+}
+
+// FetchPiece requests the piece, failing it like a web seed once
+// seedTimeout has passed
+func (hs *httpSeed) FetchPiece(ctx context.Context, index, begin, length int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, seedTimeout(hs.timeout, length))
+	defer cancel()
+	u, err := hs.pieceURL(index, length)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		// The body holds the number of seconds to wait
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 32))
+		return nil, &client.RetryAfterError{After: retryAfter(string(body), resp.Header.Get("Retry-After"))}
+	default:
+		return nil, fmt.Errorf("http seed answered %s", resp.Status)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// retryAfter reads the seconds to wait from the body of a 503 response,
+// or else from its Retry-After header. It waits client.MaxRetryAfter at
+// most.
+func retryAfter(body, header string) time.Duration {
+	for _, s := range []string{body, header} {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && seconds >= 0 {
+			return time.Duration(min(seconds, int(client.MaxRetryAfter/time.Second))) * time.Second
+		}
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,109 @@
+package torrentfile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFromHTTPSeed(t *testing.T) {
+	data := make([]byte, 50000)
+	for i := range data {
+		data[i] = byte(i * 17)
+	}
+	tracker := newTestTracker(t)
+	tf := newTestTorrentFile(tracker.URL, "archived.bin", data, 16384)
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Busy for the first request
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("0"))
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("info_hash") != string(tf.InfoHash[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		index, err := strconv.Atoi(q.Get("piece"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		begin, end := tf.calculateBoundsForPiece(index)
+		if q.Get("ranges") != "0-"+strconv.Itoa(end-begin-1) {
+			http.Error(w, "unexpected ranges", http.StatusBadRequest)
+			return
+		}
+		w.Write(data[begin:end])
+	}))
+	defer ts.Close()
+	tf.HTTPSeeds = []string{ts.URL + "/seed.php"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out := t.TempDir()
+	require.Nil(t, tf.DownloadToFile(ctx, out))
+
+	written, err := os.ReadFile(filepath.Join(out, "archived.bin"))
+	require.Nil(t, err)
+	assert.Equal(t, data, written)
+	assert.Equal(t, int32(5), requests.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 12*time.Second, retryAfter("12\n", ""))
+	assert.Equal(t, 7*time.Second, retryAfter("busy", "7"))
+	assert.Equal(t, defaultRetryAfter, retryAfter("", ""))
+	assert.Equal(t, client.MaxRetryAfter, retryAfter("", "99999999999999999"))
+}
+
+func TestBusyHTTPSeed(t *testing.T) {
+	tracker := newTestTracker(t)
+	tf := newTestTorrentFile(tracker.URL, "busy.bin", make([]byte, 1000), 512)
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("0"))
+	}))
+	defer ts.Close()
+	tf.HTTPSeeds = []string{ts.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var noPeers *client.NoPeersError
+	assert.ErrorAs(t, tf.DownloadToFile(ctx, t.TempDir()), &noPeers)
+	assert.Equal(t, int32(5), requests.Load())
+}
+
+func TestHTTPSeedTimeout(t *testing.T) {
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stalled:
+		}
+	}))
+	defer ts.Close()
+	defer close(stalled)
+
+	hs := newHTTPSeed(ts.URL, [20]byte{1}, nil)
+	hs.timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := hs.FetchPiece(context.Background(), 0, 0, 1000)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
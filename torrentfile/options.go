@@ -0,0 +1,275 @@
+package torrentfile
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/parkma99/go-bittorrent-client/ipfilter"
+	"github.com/parkma99/go-bittorrent-client/metrics"
+	"github.com/parkma99/go-bittorrent-client/proxy"
+)
+
+// Option configures a download started with DownloadToFile
+type Option func(*options)
+
+type options struct {
+	limits  client.RateLimits
+	events  *client.Bus
+	logger  *slog.Logger
+	metrics *metrics.Collector
+
+	peerID       [20]byte
+	hasPeerID    bool
+	peerIDPrefix string
+
+	dialer     client.Dialer
+	httpClient *http.Client
+
+	bindIP        net.IP
+	bindInterface string
+
+	blocklist *ipfilter.Filter
+
+	priorities []client.Priority
+	files      []int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{logger: slog.Default()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// resolveBind turns the bound interface, if any, into the address to
+// bind to. A bound address cannot be combined with a dialer it has no way
+// to apply to.
+func (o *options) resolveBind() error {
+	if o.bindInterface != "" {
+		ip, err := interfaceIP(o.bindInterface)
+		if err != nil {
+			return err
+		}
+		o.bindIP = ip
+	}
+	if o.bindIP != nil && o.dialer != nil && o.boundProxy() == nil {
+		return fmt.Errorf("cannot bind to %s with a custom dialer", o.bindIP)
+	}
+	return nil
+}
+
+// interfaceIP returns the address of the interface called name
+func interfaceIP(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	// Prefer IPv4 since the compact peer lists we ask for are IPv4 only
+	var found net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			found = ipNet.IP
+			break
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return found, nil
+}
+
+// boundProxy returns a copy of the configured proxy that reaches the proxy
+// from the bound address, or nil if the dialer is not a proxy dialing
+// directly
+func (o *options) boundProxy() client.Dialer {
+	local := &net.Dialer{Timeout: 10 * time.Second, LocalAddr: &net.TCPAddr{IP: o.bindIP}}
+	switch d := o.dialer.(type) {
+	case *proxy.SOCKS5:
+		if d.Forward == nil {
+			bound := *d
+			bound.Forward = local
+			return &bound
+		}
+	case *proxy.HTTPConnect:
+		if d.Forward == nil {
+			bound := *d
+			bound.Forward = local
+			return &bound
+		}
+	}
+	return nil
+}
+
+// peerDialer returns the dialer for peer connections. A bound address
+// applies to the connection to a proxy, or to peers when there is none.
+func (o *options) peerDialer() client.Dialer {
+	if o.bindIP != nil {
+		if o.dialer == nil {
+			return &net.Dialer{LocalAddr: &net.TCPAddr{IP: o.bindIP}}
+		}
+		if d := o.boundProxy(); d != nil {
+			return d
+		}
+	}
+	return o.dialer
+}
+
+// trackerClient returns the HTTP client for tracker requests. Without one
+// configured, tracker requests go through the peer dialer if there is one.
+func (o *options) trackerClient() *http.Client {
+	if o.httpClient != nil {
+		return o.httpClient
+	}
+	if d := o.peerDialer(); d != nil {
+		return proxy.HTTPClient(d, 15*time.Second)
+	}
+	return nil
+}
+
+// seedClient returns the HTTP client for web seeds and HTTP seeds. Their requests are
+// timed by the length of the piece, so unlike the tracker client one made
+// for the peer dialer has no overall timeout.
+func (o *options) seedClient() *http.Client {
+	if o.httpClient != nil {
+		return o.httpClient
+	}
+	if d := o.peerDialer(); d != nil {
+		return proxy.HTTPClient(d, 0)
+	}
+	return nil
+}
+
+// getPeerID returns the configured peer ID or generates a random one
+func (o *options) getPeerID() ([20]byte, error) {
+	if o.hasPeerID {
+		return o.peerID, nil
+	}
+	return client.NewPeerID(o.peerIDPrefix)
+}
+
+// WithRateLimits throttles the traffic of the download. The limiters in
+// limits may be shared with other downloads and changed while it runs.
+func WithRateLimits(limits client.RateLimits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithEvents publishes the progress of the download, peer and tracker
+// activity on bus. A Session publishes the events of all its torrents on
+// bus, Handle.Events has the events of a single one.
+func WithEvents(bus *client.Bus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// WithLogger sends the log output of the download to logger. Set its level
+// to client.LevelTrace to see every message exchanged with peers.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMetrics exports the metrics of the download through collector while
+// it runs
+func WithMetrics(collector *metrics.Collector) Option {
+	return func(o *options) {
+		o.metrics = collector
+	}
+}
+
+// WithPeerID makes the client identify itself with id, instead of a
+// randomly generated peer ID
+func WithPeerID(id [20]byte) Option {
+	return func(o *options) {
+		o.peerID = id
+		o.hasPeerID = true
+	}
+}
+
+// WithPeerIDPrefix generates the peer ID from prefix and a random suffix.
+// The prefix should follow the Azureus style, as client.DefaultPeerIDPrefix.
+func WithPeerIDPrefix(prefix string) Option {
+	return func(o *options) {
+		o.peerIDPrefix = prefix
+	}
+}
+
+// WithDialer makes connections to peers and trackers through d, for
+// example a proxy.SOCKS5 or proxy.HTTPConnect
+func WithDialer(d client.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithHTTPClient sends the requests to HTTP trackers, web seeds and HTTP
+// seeds with c
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// WithBindAddress makes peer connections, the session listener and tracker
+// requests use ip as their local address, and announces ip to trackers.
+// With a proxy.SOCKS5 or proxy.HTTPConnect dialer, the proxy is reached
+// from ip. Any other dialer cannot be combined with a bound address.
+func WithBindAddress(ip net.IP) Option {
+	return func(o *options) {
+		o.bindIP = ip
+		o.bindInterface = ""
+	}
+}
+
+// WithBindInterface is WithBindAddress with the address of the network
+// interface called name, IPv4 preferred
+func WithBindInterface(name string) Option {
+	return func(o *options) {
+		o.bindInterface = name
+	}
+}
+
+// WithBlocklist refuses connections to and from peers in f. The filter
+// may still be changed while downloads are running.
+func WithBlocklist(f *ipfilter.Filter) Option {
+	return func(o *options) {
+		o.blocklist = f
+	}
+}
+
+// WithFilePriorities sets the priority of the entries in Files, in order.
+// Files past the end of priorities keep PriorityNormal. Pieces of
+// higher priority files are downloaded first, and files with
+// PrioritySkip are not downloaded or written at all.
+func WithFilePriorities(priorities ...client.Priority) Option {
+	return func(o *options) {
+		o.priorities = priorities
+	}
+}
+
+// WithFiles downloads only the entries in Files with the given indices
+// and skips all others
+func WithFiles(indices ...int) Option {
+	return func(o *options) {
+		o.files = append([]int{}, indices...)
+	}
+}
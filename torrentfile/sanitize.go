@@ -0,0 +1,189 @@
+package torrentfile
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxComponentLength is the longest file or directory name most file
+// systems accept, in bytes
+const maxComponentLength = 255
+
+// invalidChars may not appear in a file name on this system. Separators
+// are invalid everywhere, as they would add directories.
+var invalidChars = func() string {
+	if runtime.GOOS == "windows" {
+		return `/\<>:"|?*`
+	}
+	return `/\`
+}()
+
+// sanitizeComponent returns a name that is safe to create in a directory.
+// Names that would leave the directory are rejected, invalid characters
+// are replaced and long names are shortened.
+func sanitizeComponent(name string) (string, error) {
+	switch {
+	case name == "":
+		return "", fmt.Errorf("empty path component")
+	case name == "." || name == "..":
+		return "", fmt.Errorf("path component %q leaves the download directory", name)
+	case strings.ContainsRune(name, 0):
+		return "", fmt.Errorf("path component %q contains a NUL byte", name)
+	case filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+		return "", fmt.Errorf("path component %q is absolute", name)
+	}
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(invalidChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runtime.GOOS == "windows" {
+		// Windows drops trailing dots and spaces
+		name = strings.TrimRight(name, ". ")
+		if name == "" {
+			name = "_"
+		}
+		name = renameReserved(name)
+	}
+	if len(name) > maxComponentLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxComponentLength/8 {
+			ext = ""
+		}
+		base := name[:maxComponentLength-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name, nil
+}
+
+// renameReserved appends an underscore to the base of names that Windows
+// reserves for devices, such as CON or nul.txt, which cannot be files
+func renameReserved(name string) string {
+	base, ext, dotted := strings.Cut(name, ".")
+	switch upper := strings.ToUpper(strings.TrimRight(base, " ")); upper {
+	case "CON", "PRN", "AUX", "NUL":
+	default:
+		if len(upper) != 4 || (upper[:3] != "COM" && upper[:3] != "LPT") || upper[3] < '0' || upper[3] > '9' {
+			return name
+		}
+	}
+	if !dotted {
+		return base + "_"
+	}
+	return base + "_." + ext
+}
+
+// sanitizePaths makes Name and the paths of all files safe to create
+// below a download directory, and rejects torrents where two files would
+// end up at the same path
+func (t *TorrentFile) sanitizePaths() error {
+	name, err := sanitizeComponent(t.Name)
+	if err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	t.Name = name
+	if len(t.Files) == 0 {
+		// A link needs another file of the torrent to point to
+		if t.file(0).symlink() {
+			return errors.New("single-file torrent is a symlink")
+		}
+		return nil
+	}
+	files := make([]fileInfo, len(t.Files))
+	for i, f := range t.Files {
+		if len(f.Path) == 0 {
+			return fmt.Errorf("file %d has an empty path", i)
+		}
+		path := make([]string, len(f.Path))
+		for j, component := range f.Path {
+			if path[j], err = sanitizeComponent(component); err != nil {
+				return fmt.Errorf("invalid path of file %d: %w", i, err)
+			}
+		}
+		f.Path = path
+		if f.symlink() {
+			// The target is sanitized like a path, so that the link
+			// stays within the torrent and finds the file it names
+			if len(f.SymlinkPath) == 0 {
+				return fmt.Errorf("symlink %d has no target", i)
+			}
+			target := make([]string, len(f.SymlinkPath))
+			for j, component := range f.SymlinkPath {
+				if target[j], err = sanitizeComponent(component); err != nil {
+					return fmt.Errorf("invalid symlink path of file %d: %w", i, err)
+				}
+			}
+			f.SymlinkPath = target
+		}
+		files[i] = f
+	}
+	if err := checkDuplicatePaths(files); err != nil {
+		return err
+	}
+	t.Files = files
+	return nil
+}
+
+// checkPaths fails when Name or a file path is not what sanitizePaths
+// would make of it, for torrents that did not come from Open
+func (t *TorrentFile) checkPaths() error {
+	sanitized := TorrentFile{Name: t.Name, Files: t.Files}
+	if err := sanitized.sanitizePaths(); err != nil {
+		return err
+	}
+	if sanitized.Name != t.Name {
+		return fmt.Errorf("unsafe name %q", t.Name)
+	}
+	for i, f := range sanitized.Files {
+		if strings.Join(f.Path, "/") != strings.Join(t.Files[i].Path, "/") {
+			return fmt.Errorf("unsafe path of file %d %q", i, t.Files[i].Path)
+		}
+		if strings.Join(f.SymlinkPath, "/") != strings.Join(t.Files[i].SymlinkPath, "/") {
+			return fmt.Errorf("unsafe symlink path of file %d %q", i, t.Files[i].SymlinkPath)
+		}
+	}
+	return nil
+}
+
+// checkDuplicatePaths fails when two files have the same path or a file
+// is in the place of another file's directory. Paths are compared without
+// case, as case-insensitive file systems would merge them. Padding files
+// are never written and may share a path.
+func checkDuplicatePaths(files []fileInfo) error {
+	paths := make(map[string]int, len(files))
+	dirs := make(map[string]int)
+	for i, f := range files {
+		if f.padding() {
+			continue
+		}
+		folded := make([]string, len(f.Path))
+		for k, component := range f.Path {
+			folded[k] = strings.ToLower(component)
+		}
+		path := strings.Join(folded, "/")
+		if j, ok := paths[path]; ok {
+			return fmt.Errorf("files %d and %d have the same path %q", j, i, path)
+		}
+		if j, ok := dirs[path]; ok {
+			return fmt.Errorf("file %d is at %q, which is a directory of file %d", i, path, j)
+		}
+		paths[path] = i
+		for k := 1; k < len(folded); k++ {
+			dir := strings.Join(folded[:k], "/")
+			if j, ok := paths[dir]; ok {
+				return fmt.Errorf("file %d is at %q, which is a directory of file %d", j, dir, i)
+			}
+			dirs[dir] = i
+		}
+	}
+	return nil
+}
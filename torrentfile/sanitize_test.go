@@ -0,0 +1,96 @@
+package torrentfile
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeComponent(t *testing.T) {
+	for _, bad := range []string{"", ".", "..", "a\x00b", "/etc"} {
+		_, err := sanitizeComponent(bad)
+		assert.Error(t, err, "%q", bad)
+	}
+
+	name, err := sanitizeComponent("a/b\\c\td")
+	require.NoError(t, err)
+	assert.Equal(t, "a_b_c_d", name)
+
+	name, err = sanitizeComponent("bad \xff utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, "bad _ utf-8", name)
+
+	long := "x" + strings.Repeat("ä", 200) + ".flac"
+	name, err = sanitizeComponent(long)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(name), maxComponentLength)
+	assert.Equal(t, "x"+strings.Repeat("ä", 124)+".flac", name)
+}
+
+func TestRenameReserved(t *testing.T) {
+	for name, expected := range map[string]string{
+		"CON":         "CON_",
+		"nul.txt":     "nul_.txt",
+		"Com1.tar.gz": "Com1_.tar.gz",
+		"lpt9":        "lpt9_",
+		"CONSOLE":     "CONSOLE",
+		"COM":         "COM",
+		"auxiliary":   "auxiliary",
+		"my.con":      "my.con",
+	} {
+		assert.Equal(t, expected, renameReserved(name), name)
+	}
+}
+
+func TestSanitizePaths(t *testing.T) {
+	tf := TorrentFile{Name: "album", Files: []fileInfo{
+		{Length: 1, Path: []string{"disc 1", "a:b"}},
+		{Length: 1, Path: []string{"x/y"}},
+	}}
+	require.NoError(t, tf.sanitizePaths())
+	assert.Equal(t, []string{"x_y"}, tf.Files[1].Path)
+	assert.NoError(t, tf.checkPaths())
+
+	for _, files := range [][]fileInfo{
+		{{Path: []string{"..", "passwd"}}},
+		{{Path: []string{"a", "", "b"}}},
+		{{Path: nil}},
+		{{Path: []string{"a"}}, {Path: []string{"a"}}},
+		{{Path: []string{"a"}}, {Path: []string{"a", "b"}}},
+		{{Path: []string{"a", "b"}}, {Path: []string{"a"}}},
+		{{Path: []string{"a/b"}}, {Path: []string{"a_b"}}},
+		{{Path: []string{"README"}}, {Path: []string{"readme"}}},
+		{{Path: []string{"Docs", "a"}}, {Path: []string{"docs"}}},
+	} {
+		tf := TorrentFile{Name: "album", Files: files}
+		assert.Error(t, tf.sanitizePaths(), "%v", files)
+	}
+
+	tf = TorrentFile{Name: ".."}
+	assert.Error(t, tf.sanitizePaths())
+}
+
+func TestOpenRejectsTraversal(t *testing.T) {
+	in := "d4:infod5:filesld6:lengthi4e4:pathl2:..6:escapeeee4:name1:a12:piece lengthi4e6:pieces20:aaaaaaaaaaaaaaaaaaaaee"
+	path := filepath.Join(t.TempDir(), "evil.torrent")
+	require.NoError(t, os.WriteFile(path, []byte(in), 0o644))
+	_, err := Open(path)
+	assert.ErrorContains(t, err, "leaves the download directory")
+}
+
+func TestSaveToDiskChecksPaths(t *testing.T) {
+	dir := t.TempDir()
+	tf := TorrentFile{Name: "a", Length: 4, PieceLength: 4, Files: []fileInfo{
+		{Length: 4, Path: []string{"..", "..", "escape"}},
+	}}
+	err := tf.saveToDisk(strings.NewReader("data"), filepath.Join(dir, "out"), nil, slog.Default())
+	assert.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
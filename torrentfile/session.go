@@ -0,0 +1,414 @@
+package torrentfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/parkma99/go-bittorrent-client/ratelimit"
+)
+
+// TorrentState is the lifecycle state of a torrent in a Session
+type TorrentState int
+
+const (
+	StateDownloading TorrentState = iota
+	StatePaused
+	StateCompleted
+	StateFailed
+)
+
+func (s TorrentState) String() string {
+	switch s {
+	case StateDownloading:
+		return "downloading"
+	case StatePaused:
+		return "paused"
+	case StateCompleted:
+		return "completed"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("TorrentState(%d)", int(s))
+	}
+}
+
+// Session manages many torrents in one process. They share the session's
+// listening socket, peer ID, rate limiters and options. A peer banned for
+// sending corrupt data to one torrent is banned for all of them.
+type Session struct {
+	o        *options
+	peerID   [20]byte
+	port     uint16
+	listener net.Listener
+	upload   *ratelimit.Limiter
+	download *ratelimit.Limiter
+	bans     *client.BanList
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	torrents map[[20]byte]*Handle
+	// swarms maps the info hash of every swarm a torrent is in to its
+	// InfoHash, which differ for the v2 swarm of a hybrid torrent
+	swarms map[[20]byte][20]byte
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Handle is a torrent added to a Session
+type Handle struct {
+	s    *Session
+	tf   *TorrentFile
+	path string
+	d    *download
+
+	mu      sync.Mutex
+	state   TorrentState
+	err     error
+	cancel  context.CancelFunc
+	done    chan struct{}
+	removed bool
+}
+
+// NewSession starts a session listening for peers on listenAddr, for
+// example ":6881". The options apply to every torrent added to it.
+// With WithBindAddress or WithBindInterface the listener binds to that
+// address unless listenAddr names another one.
+func NewSession(listenAddr string, opts ...Option) (*Session, error) {
+	o := newOptions(opts)
+	if err := o.resolveBind(); err != nil {
+		return nil, err
+	}
+	peerID, err := o.getPeerID()
+	if err != nil {
+		return nil, err
+	}
+	// A bound address replaces an unspecified listen host
+	if o.bindIP != nil {
+		host, port, err := net.SplitHostPort(listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			listenAddr = net.JoinHostPort(o.bindIP.String(), port)
+		}
+	}
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		o:        o,
+		peerID:   peerID,
+		port:     uint16(ln.Addr().(*net.TCPAddr).Port),
+		listener: ln,
+		upload:   o.limits.SessionUpload,
+		download: o.limits.SessionDownload,
+		bans:     &client.BanList{},
+		log:      o.logger,
+		torrents: make(map[[20]byte]*Handle),
+		swarms:   make(map[[20]byte][20]byte),
+	}
+	// Keep session wide limiters around even when unlimited, so that
+	// SetRateLimits can change them later
+	if s.upload == nil {
+		s.upload = ratelimit.New(0)
+	}
+	if s.download == nil {
+		s.download = ratelimit.New(0)
+	}
+	s.o.limits.SessionUpload = s.upload
+	s.o.limits.SessionDownload = s.download
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Port returns the port the session listens on
+func (s *Session) Port() uint16 {
+	return s.port
+}
+
+// SetRateLimits changes the session wide upload and download rates in
+// bytes per second. Zero means unlimited.
+func (s *Session) SetRateLimits(upload, download int) {
+	s.upload.SetLimit(upload)
+	s.download.SetLimit(download)
+}
+
+func (s *Session) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Warn("accept failed", slog.Any("error", err))
+			}
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn routes an incoming connection to the torrent it asks for
+func (s *Session) handleConn(conn net.Conn) {
+	// Blocked peers do not even get to send their handshake
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && (s.o.blocklist.Blocked(addr.IP) || s.bans.Banned(addr.IP)) {
+		s.log.Debug("refused blocked or banned peer", slog.String("peer", addr.String()))
+		conn.Close()
+		return
+	}
+	infoHash, peerID, err := client.ReadIncomingHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	h, ok := s.Torrent(infoHash)
+	if !ok {
+		conn.Close()
+		return
+	}
+	if err := h.d.torrent.AddConn(conn, infoHash, peerID); err != nil {
+		s.log.Debug("refused incoming peer",
+			slog.String("peer", conn.RemoteAddr().String()), slog.Any("error", err))
+	}
+}
+
+// Add starts downloading t below path. opts apply to this torrent only,
+// on top of the session's options, and are meant for options like
+// WithFiles that concern a single torrent.
+func (s *Session) Add(t *TorrentFile, path string, opts ...Option) (*Handle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("session is closed")
+	}
+	for _, infoHash := range t.infoHashes() {
+		if _, ok := s.swarms[infoHash]; ok {
+			return nil, fmt.Errorf("torrent %x is already in the session", infoHash)
+		}
+	}
+	o := *s.o
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Each torrent publishes on a bus of its own, so that its metrics and
+	// progress only count its events, and forwards them to the shared bus
+	bus := &client.Bus{}
+	if o.events != nil {
+		bus.Subscribe(o.events.Publish)
+	}
+	o.events = bus
+	d, err := t.newDownload(&o, s.peerID, s.port)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handle{
+		s:    s,
+		tf:   t,
+		path: path,
+		d:    d,
+	}
+	h.d.torrent.Listening = true
+	h.d.torrent.Bans = s.bans
+	s.torrents[t.InfoHash] = h
+	for _, infoHash := range t.infoHashes() {
+		s.swarms[infoHash] = t.InfoHash
+	}
+	h.start()
+	return h, nil
+}
+
+// Torrent returns the torrent with the given info hash, which may be the
+// truncated v2 info hash of a hybrid torrent
+func (s *Session) Torrent(infoHash [20]byte) (*Handle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.torrents[s.swarms[infoHash]]
+	return h, ok
+}
+
+// Torrents returns every torrent in the session
+func (s *Session) Torrents() []*Handle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handles := make([]*Handle, 0, len(s.torrents))
+	for _, h := range s.torrents {
+		handles = append(handles, h)
+	}
+	return handles
+}
+
+// Remove stops a torrent and removes it from the session. Files already
+// written to disk are left alone.
+func (s *Session) Remove(infoHash [20]byte) error {
+	s.mu.Lock()
+	h, ok := s.torrents[s.swarms[infoHash]]
+	if ok {
+		delete(s.torrents, h.tf.InfoHash)
+		for _, infoHash := range h.tf.infoHashes() {
+			delete(s.swarms, infoHash)
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("torrent %x is not in the session", infoHash)
+	}
+	h.remove()
+	h.d.close()
+	return nil
+}
+
+// Close stops every torrent and the listener
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	handles := s.torrents
+	s.torrents = make(map[[20]byte]*Handle)
+	s.swarms = make(map[[20]byte][20]byte)
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	for _, h := range handles {
+		h.remove()
+		h.d.close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// InfoHash returns the info hash of the torrent
+func (h *Handle) InfoHash() [20]byte {
+	return h.tf.InfoHash
+}
+
+// State returns the state of the torrent, and the error that made it fail
+func (h *Handle) State() (TorrentState, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state, h.err
+}
+
+// Stats returns the bytes exchanged with the torrent's peers
+func (h *Handle) Stats() client.Stats {
+	return h.d.torrent.Stats()
+}
+
+// Events returns the bus on which the torrent publishes its events, which
+// are also forwarded to the bus of WithEvents
+func (h *Handle) Events() *client.Bus {
+	return h.d.torrent.Events
+}
+
+// NewReader streams the whole torrent while it downloads, see
+// client.Reader. The reader must be closed after use.
+func (h *Handle) NewReader(ctx context.Context) *client.Reader {
+	return h.d.torrent.NewReader(ctx)
+}
+
+// NewFileReader streams entry i of the torrent's Files, or the single
+// file of a single-file torrent with i == 0
+func (h *Handle) NewFileReader(ctx context.Context, i int) (*client.Reader, error) {
+	if i < 0 || i >= h.tf.numFiles() {
+		return nil, fmt.Errorf("file index %d out of range", i)
+	}
+	begin, end := h.tf.fileBounds(i)
+	return h.d.torrent.NewSectionReader(ctx, int64(begin), int64(end-begin)), nil
+}
+
+// Wait blocks until the torrent completed, failed or was paused, or until
+// ctx is done
+func (h *Handle) Wait(ctx context.Context) (TorrentState, error) {
+	h.mu.Lock()
+	done := h.done
+	h.mu.Unlock()
+	select {
+	case <-done:
+		return h.State()
+	case <-ctx.Done():
+		return StateDownloading, ctx.Err()
+	}
+}
+
+// Pause stops the download but keeps the pieces downloaded so far
+func (h *Handle) Pause() error {
+	h.mu.Lock()
+	if h.state != StateDownloading {
+		state := h.state
+		h.mu.Unlock()
+		return fmt.Errorf("cannot pause a %s torrent", state)
+	}
+	h.mu.Unlock()
+	h.stop()
+	return nil
+}
+
+// Resume restarts a paused or failed download. It fails once the torrent
+// was removed or the session closed.
+func (h *Handle) Resume() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.removed {
+		return errors.New("torrent was removed from the session")
+	}
+	if h.state != StatePaused && h.state != StateFailed {
+		return fmt.Errorf("cannot resume a %s torrent", h.state)
+	}
+	h.start()
+	return nil
+}
+
+// start runs the download in the background. h.mu must be held or h must
+// not be shared yet.
+func (h *Handle) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	h.state = StateDownloading
+	h.err = nil
+	h.cancel = cancel
+	h.done = done
+
+	h.s.wg.Add(1)
+	go func() {
+		defer h.s.wg.Done()
+		defer close(done)
+		err := h.d.run(ctx, h.path)
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		switch {
+		case err == nil:
+			h.state = StateCompleted
+		case ctx.Err() != nil:
+			h.state = StatePaused
+		default:
+			h.state = StateFailed
+			h.err = err
+		}
+	}()
+}
+
+// remove stops the download for good, Resume fails afterwards
+func (h *Handle) remove() {
+	h.mu.Lock()
+	h.removed = true
+	h.mu.Unlock()
+	h.stop()
+}
+
+// stop cancels the running download, if any, and waits for it to end
+func (h *Handle) stop() {
+	h.mu.Lock()
+	cancel, done := h.cancel, h.done
+	h.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,267 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/parkma99/go-bittorrent-client/metrics"
+	"github.com/parkma99/go-bittorrent-client/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTracker returns a tracker that never knows any peers
+func newTestTracker(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestTorrentFile(announce, name string, data []byte, pieceLength int) *TorrentFile {
+	tf := &TorrentFile{
+		Announce:    announce,
+		InfoHash:    sha1.Sum([]byte(name)),
+		PieceLength: pieceLength,
+		Length:      len(data),
+		Name:        name,
+	}
+	for begin := 0; begin < len(data); begin += pieceLength {
+		end := begin + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		tf.PieceHashes = append(tf.PieceHashes, sha1.Sum(data[begin:end]))
+	}
+	return tf
+}
+
+// seedTo connects to a session as a seeder of data and serves requests
+// until the connection is closed
+func seedTo(port uint16, infoHash [20]byte, data []byte, pieceLength int) {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	handshake := append([]byte{19}, "BitTorrent protocol"...)
+	handshake = append(handshake, make([]byte, 8)...)
+	handshake = append(handshake, infoHash[:]...)
+	handshake = append(handshake, "-TS0001-000000000000"...)
+	conn.Write(handshake)
+	// The session hangs up on torrents that are not running yet
+	if _, err := io.ReadFull(conn, make([]byte, len(handshake))); err != nil {
+		return
+	}
+
+	numPieces := (len(data) + pieceLength - 1) / pieceLength
+	bitfield := make([]byte, (numPieces+7)/8)
+	for i := 0; i < numPieces; i++ {
+		bitfield[i/8] |= 1 << (7 - i%8)
+	}
+	conn.Write(append([]byte{0, 0, 0, byte(len(bitfield) + 1), 5}, bitfield...))
+	conn.Write([]byte{0, 0, 0, 1, 1}) // unchoke
+
+	for {
+		lengthBuf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(lengthBuf))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		if len(msg) != 13 || msg[0] != 6 { // only answer requests
+			continue
+		}
+		index := int(binary.BigEndian.Uint32(msg[1:5]))
+		begin := int(binary.BigEndian.Uint32(msg[5:9]))
+		length := int(binary.BigEndian.Uint32(msg[9:13]))
+		start := index*pieceLength + begin
+		piece := make([]byte, 4+1+8+length)
+		binary.BigEndian.PutUint32(piece[0:4], uint32(9+length))
+		piece[4] = 7
+		copy(piece[5:13], msg[1:9])
+		copy(piece[13:], data[start:start+length])
+		if _, err := conn.Write(piece); err != nil {
+			return
+		}
+	}
+}
+
+func TestSessionIncomingPeer(t *testing.T) {
+	tracker := newTestTracker(t)
+	data := make([]byte, 50000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tf := newTestTorrentFile(tracker.URL, "incoming.bin", data, 16384)
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Close()
+
+	dir := t.TempDir()
+	h, err := s.Add(tf, dir)
+	require.Nil(t, err)
+	_, err = s.Add(tf, dir)
+	assert.NotNil(t, err)
+
+	// The torrent only accepts peers once it announced, keep knocking
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			seedTo(s.Port(), tf.InfoHash, data, tf.PieceLength)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	state, err := h.Wait(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, StateCompleted, state)
+	written, err := os.ReadFile(filepath.Join(dir, tf.Name))
+	require.Nil(t, err)
+	assert.Equal(t, data, written)
+	assert.Equal(t, int64(len(data)), h.Stats().PayloadDownloaded)
+}
+
+func TestSessionPauseResume(t *testing.T) {
+	tracker := newTestTracker(t)
+	tf := newTestTorrentFile(tracker.URL, "paused.bin", make([]byte, 1000), 512)
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Close()
+
+	h, err := s.Add(tf, t.TempDir())
+	require.Nil(t, err)
+	assert.Equal(t, []*Handle{h}, s.Torrents())
+
+	require.Nil(t, h.Pause())
+	state, _ := h.State()
+	assert.Equal(t, StatePaused, state)
+	assert.NotNil(t, h.Pause())
+
+	require.Nil(t, h.Resume())
+	state, _ = h.State()
+	assert.Equal(t, StateDownloading, state)
+
+	require.Nil(t, s.Remove(tf.InfoHash))
+	_, ok := s.Torrent(tf.InfoHash)
+	assert.False(t, ok)
+	assert.NotNil(t, s.Remove(tf.InfoHash))
+	assert.NotNil(t, h.Resume())
+}
+
+func TestSessionResumeAfterClose(t *testing.T) {
+	tracker := newTestTracker(t)
+	tf := newTestTorrentFile(tracker.URL, "closed.bin", make([]byte, 1000), 512)
+
+	s, err := NewSession("127.0.0.1:0")
+	require.Nil(t, err)
+	h, err := s.Add(tf, t.TempDir())
+	require.Nil(t, err)
+
+	require.Nil(t, s.Close())
+	state, _ := h.State()
+	assert.Equal(t, StatePaused, state)
+	assert.NotNil(t, h.Resume())
+	state, _ = h.State()
+	assert.Equal(t, StatePaused, state)
+}
+
+func TestSessionEvents(t *testing.T) {
+	tracker := newTestTracker(t)
+	registry := metrics.NewRegistry()
+	shared := &client.Bus{}
+	s, err := NewSession("127.0.0.1:0", WithEvents(shared),
+		WithMetrics(metrics.NewCollector(registry)))
+	require.Nil(t, err)
+	defer s.Close()
+
+	h1, err := s.Add(newTestTorrentFile(tracker.URL, "one.bin", make([]byte, 1000), 512), t.TempDir())
+	require.Nil(t, err)
+	h2, err := s.Add(newTestTorrentFile(tracker.URL, "two.bin", make([]byte, 1000), 512), t.TempDir())
+	require.Nil(t, err)
+
+	var mu sync.Mutex
+	var seen, other int
+	shared.Subscribe(func(e client.Event) {
+		if _, ok := e.(client.PieceVerified); ok {
+			mu.Lock()
+			seen++
+			mu.Unlock()
+		}
+	})
+	h2.Events().Subscribe(func(e client.Event) {
+		if _, ok := e.(client.PieceVerified); ok {
+			mu.Lock()
+			other++
+			mu.Unlock()
+		}
+	})
+	h1.Events().Publish(client.PieceVerified{Index: 0})
+
+	mu.Lock()
+	assert.Equal(t, 1, seen)
+	assert.Equal(t, 0, other)
+	mu.Unlock()
+	buf := &bytes.Buffer{}
+	_, err = registry.WriteTo(buf)
+	require.Nil(t, err)
+	verified := `bittorrent_pieces_total{infohash="%x",result="verified"} 1`
+	assert.Contains(t, buf.String(), fmt.Sprintf(verified, h1.InfoHash()))
+	assert.NotContains(t, buf.String(), fmt.Sprintf(verified, h2.InfoHash()))
+}
+
+func TestSessionBind(t *testing.T) {
+	s, err := NewSession(":0", WithBindAddress(net.IPv4(127, 0, 0, 1)))
+	require.Nil(t, err)
+	defer s.Close()
+	addr := s.listener.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.Equal(net.IPv4(127, 0, 0, 1)))
+
+	s, err = NewSession(":0", WithBindInterface("lo"))
+	if err == nil {
+		addr := s.listener.Addr().(*net.TCPAddr)
+		assert.True(t, addr.IP.IsLoopback())
+		s.Close()
+	}
+
+	_, err = NewSession(":0", WithBindInterface("no-such-interface"))
+	assert.NotNil(t, err)
+}
+
+func TestBindThroughProxy(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	socks := &proxy.SOCKS5{Addr: "127.0.0.1:1080"}
+	o := newOptions([]Option{WithDialer(socks), WithBindAddress(ip)})
+	require.Nil(t, o.resolveBind())
+	bound, ok := o.peerDialer().(*proxy.SOCKS5)
+	require.True(t, ok)
+	assert.Equal(t, socks.Addr, bound.Addr)
+	assert.Nil(t, socks.Forward)
+	forward, ok := bound.Forward.(*net.Dialer)
+	require.True(t, ok)
+	assert.True(t, forward.LocalAddr.(*net.TCPAddr).IP.Equal(ip))
+
+	_, err := NewSession(":0", WithDialer(&net.Dialer{}), WithBindAddress(ip))
+	assert.ErrorContains(t, err, "custom dialer")
+}
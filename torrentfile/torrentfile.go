@@ -1,17 +1,21 @@
 package torrentfile
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/parkma99/go-bittorrent-client/bencode"
 	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/parkma99/go-bittorrent-client/peers"
 )
 
 // Port to listen on
@@ -19,31 +23,96 @@ const Port uint16 = 65534
 
 // TorrentFile encodes the metadata from a .torrent file
 type TorrentFile struct {
-	Announce    string
+	Announce string
+	// AnnounceList holds tiers of trackers (BEP 12)
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	// CreationDate is the zero Time when the torrent has none
+	CreationDate time.Time
+	// Encoding is the character set of the strings in the info dictionary
+	Encoding    string
 	InfoHash    [20]byte
 	PieceHashes [][20]byte
 	PieceLength int
 	Length      int
-	Name        string
-	Files       []fileInfo
+	// Name is taken from name.utf-8 when the torrent has it
+	Name  string
+	Files []fileInfo
+	// Attr and SHA1 are the BEP 47 attributes and hash of the file of a
+	// single-file torrent
+	Attr string
+	SHA1 string
+	// Private and Source belong to the info dictionary, changing them
+	// does not change what WriteTo writes
+	Private bool
+	Source  string
+	// URLList holds the BEP 19 web seeds
+	URLList []string
+	// HTTPSeeds holds the BEP 17 HTTP seeds
+	HTTPSeeds []string
+	// MetaVersion is 2 for a BitTorrent v2 or hybrid torrent (BEP 52)
+	MetaVersion int
+	// InfoHashV2 is the SHA-256 hash of the info dictionary of a v2
+	// torrent. A torrent that is only v2 uses its first 20 bytes as
+	// InfoHash.
+	InfoHashV2 [32]byte
+	// FilesV2 holds the files of the file tree of a v2 torrent, sorted by
+	// path, with their piece layers
+	FilesV2 []client.FileV2
+
+	// info is the info dictionary as it was read, which WriteTo writes
+	// back unchanged so the info hash stays the same
+	info []byte
+	// extra holds the top-level keys TorrentFile has no field for
+	extra map[string]*bencode.BObject
+	// offsets holds where every file begins, see fileOffsets
+	offsets []int
 }
 
+// fileInfo is a file of a multi-file torrent. Path is taken from
+// path.utf-8 when the torrent has it.
 type fileInfo struct {
-	Length int      `bencode:"length"`
-	Path   []string `bencode:"path"`
+	// Attr holds the BEP 47 attributes of the file: p for padding, x for
+	// executable, h for hidden and l for a symlink to SymlinkPath
+	Attr     string   `bencode:"attr,omitempty"`
+	Length   int      `bencode:"length"`
+	MD5Sum   string   `bencode:"md5sum,omitempty"`
+	Path     []string `bencode:"path"`
+	PathUTF8 []string `bencode:"path.utf-8,omitempty"`
+	// SHA1 is the SHA-1 hash of the file, checked before it is written
+	SHA1        string   `bencode:"sha1,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
 }
 
+// The bencode structs declare their fields sorted by key, which is the
+// order Marshal writes them in
 type bencodeInfo struct {
-	Length      int        `bencode:"length"`
-	Files       []fileInfo `bencode:"files"`
-	Name        string     `bencode:"name"`
-	PieceLength int        `bencode:"piece length"`
-	Pieces      string     `bencode:"pieces"`
+	Attr string `bencode:"attr,omitempty"`
+	// FileTree is only written, parse reads the file tree by hand
+	FileTree    map[string]any `bencode:"file tree,omitempty"`
+	Files       []fileInfo     `bencode:"files,omitempty"`
+	Length      int            `bencode:"length,omitempty"`
+	MetaVersion int            `bencode:"meta version,omitempty"`
+	Name        string         `bencode:"name"`
+	NameUTF8    string         `bencode:"name.utf-8,omitempty"`
+	PieceLength int            `bencode:"piece length"`
+	Pieces      string         `bencode:"pieces"`
+	Private     int            `bencode:"private,omitempty"`
+	SHA1        string         `bencode:"sha1,omitempty"`
+	Source      string         `bencode:"source,omitempty"`
 }
 
 type bencodeTorrent struct {
-	Announce string      `bencode:"announce"`
-	Info     bencodeInfo `bencode:"info"`
+	Announce     string            `bencode:"announce,omitempty"`
+	AnnounceList [][]string        `bencode:"announce-list,omitempty"`
+	Comment      string            `bencode:"comment,omitempty"`
+	CreatedBy    string            `bencode:"created by,omitempty"`
+	CreationDate int               `bencode:"creation date,omitempty"`
+	Encoding     string            `bencode:"encoding,omitempty"`
+	Info         bencodeInfo       `bencode:"info"`
+	PieceLayers  map[string]string `bencode:"piece layers,omitempty"`
+	URLList      []string          `bencode:"url-list,omitempty"`
 }
 
 func Open(path string) (TorrentFile, error) {
@@ -52,7 +121,12 @@ func Open(path string) (TorrentFile, error) {
 		return TorrentFile{}, err
 	}
 	defer file.Close()
-	o, _, err := bencode.Bdecode(file)
+	return parse(file)
+}
+
+// parse reads the metainfo of a torrent from r
+func parse(r io.Reader) (TorrentFile, error) {
+	o, _, err := bencode.Bdecode(r)
 	if err != nil {
 		return TorrentFile{}, err
 	}
@@ -70,83 +144,267 @@ func Open(path string) (TorrentFile, error) {
 	if err != nil {
 		return TorrentFile{}, err
 	}
-	return bto.toTorrentFile(info_bytes)
+	t, err := bto.toTorrentFile(info_bytes)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	if err := t.parseV2(dir); err != nil {
+		return TorrentFile{}, err
+	}
+	t.offsets = t.fileOffsets()
+	info, err := info_obj.Dict()
+	if err != nil {
+		return TorrentFile{}, fmt.Errorf("invalid info: %w", err)
+	}
+	if err := t.validate(info); err != nil {
+		return TorrentFile{}, err
+	}
+	if err := t.sanitizePaths(); err != nil {
+		return TorrentFile{}, err
+	}
+	// url-list is a string or a list, which Unmarshal cannot express
+	if urlList, ok := dir["url-list"]; ok {
+		t.URLList = stringList(urlList)
+	}
+	if httpSeeds, ok := dir["httpseeds"]; ok {
+		t.HTTPSeeds = stringList(httpSeeds)
+	}
+	for key, value := range dir {
+		if !knownKeys[key] {
+			if t.extra == nil {
+				t.extra = make(map[string]*bencode.BObject)
+			}
+			t.extra[key] = value
+		}
+	}
+	return t, nil
 }
 
-func (t *TorrentFile) DownloadToFile(path string) error {
-	var peerID [20]byte
-	copy(peerID[:], "-qB3150-123456789000")
-	peers, err := t.requestPeers(peerID, Port)
+// DownloadToFile downloads the torrent and writes it below path. When ctx
+// is done the download is stopped, the tracker is told so, and ctx.Err()
+// is returned.
+func (t *TorrentFile) DownloadToFile(ctx context.Context, path string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.resolveBind(); err != nil {
+		return err
+	}
+	peerID, err := o.getPeerID()
 	if err != nil {
 		return err
 	}
+	d, err := t.newDownload(o, peerID, Port)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+	return d.run(ctx, path)
+}
+
+// download ties a TorrentFile to the client.Torrent fetching its pieces.
+// It can be run again after it was stopped and keeps the pieces it
+// already has.
+type download struct {
+	tf      *TorrentFile
+	torrent *client.Torrent
+	http    *http.Client
+	req     announceRequest
+	log     *slog.Logger
+	untrack func()
+	// files holds the priority of every file, nil when all are wanted
+	files []client.Priority
+}
 
-	torrent := client.Torrent{
-		Peers:       peers,
-		PeerID:      peerID,
-		InfoHash:    t.InfoHash,
-		PieceHashes: t.PieceHashes,
-		PieceLength: t.PieceLength,
-		Length:      t.Length,
-		Name:        t.Name,
+func (t *TorrentFile) newDownload(o *options, peerID [20]byte, port uint16) (*download, error) {
+	// Fail before downloading anything that could not be saved
+	if err := t.checkPaths(); err != nil {
+		return nil, err
 	}
-	buf, err := torrent.Download()
+	files, err := o.filePriorities(t)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	key, err := newTrackerKey()
+	if err != nil {
+		return nil, err
+	}
+	d := &download{
+		tf: t,
+		torrent: &client.Torrent{
+			PeerID:      peerID,
+			InfoHash:    t.InfoHash,
+			PieceHashes: t.PieceHashes,
+			PieceLength: t.PieceLength,
+			Length:      t.bufferLength(),
+			Name:        t.Name,
+			Limits:      o.limits,
+			Events:      o.events,
+			Logger:      o.logger,
+			Dialer:      o.peerDialer(),
+			Blocklist:   o.blocklist,
+			Priorities:  t.piecePriorities(files),
+		},
+		http:  o.trackerClient(),
+		req:   announceRequest{peerID: peerID, port: port, ip: o.bindIP, key: key},
+		log:   o.logger.With(slog.String("infohash", hex.EncodeToString(t.InfoHash[:]))),
+		files: files,
+	}
+	if t.MetaVersion == 2 {
+		d.torrent.FilesV2 = t.FilesV2
+	}
+	if t.hybrid() {
+		copy(d.torrent.InfoHashV2[:], t.InfoHashV2[:])
 	}
+	seeds := o.seedClient()
+	for _, u := range t.URLList {
+		d.torrent.Sources = append(d.torrent.Sources, newWebSeed(u, t, seeds))
+	}
+	for _, u := range t.HTTPSeeds {
+		d.torrent.Sources = append(d.torrent.Sources, newHTTPSeed(u, t.InfoHash, seeds))
+	}
+	if o.metrics != nil {
+		if d.torrent.Events == nil {
+			d.torrent.Events = &client.Bus{}
+		}
+		d.untrack = o.metrics.Track(d.torrent)
+	}
+	return d, nil
+}
 
-	err = t.saveToDisk(buf, path)
+func (d *download) close() {
+	if d.untrack != nil {
+		d.untrack()
+	}
+}
+
+func (d *download) run(ctx context.Context, path string) error {
+	t, log := d.tf, d.log
+	d.loadPartFile(path)
+	// The tracker is the only source of peers besides incoming
+	// connections, which is all BEP 27 allows for private torrents
+	peers, err := d.requestPeers(ctx, t.InfoHash)
 	if err != nil {
 		return err
 	}
-	return nil
+	d.torrent.Peers = peers
+	if t.hybrid() {
+		// The v2 swarm comes on top, the download works without it
+		d.torrent.PeersV2, _ = d.requestPeers(ctx, t.infoHashes()[1])
+	}
+
+	if err := d.torrent.Download(ctx); err != nil {
+		// ctx may already be done, the stopped event gets a few seconds of its own
+		stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if serr := d.sendEvent(stopCtx, eventStopped); serr != nil {
+			log.Warn("could not send event to tracker", slog.String("event", eventStopped), slog.Any("error", serr))
+		}
+		return err
+	}
+	if err := d.sendEvent(ctx, eventCompleted); err != nil {
+		log.Warn("could not send event to tracker", slog.String("event", eventCompleted), slog.Any("error", err))
+	}
+
+	return t.saveToDisk(d.torrent, path, d.files, log)
 }
 
-func (t *TorrentFile) saveToDisk(buf []byte, path string) error {
-	if len(t.Files) == 0 {
-		err := os.MkdirAll(path, os.ModePerm) // Create directories recursively if they don't exist
-		if err != nil {
-			return err
+// loadPartFile hands the pieces kept by an earlier download with skipped
+// files to the torrent. The part file is only a cache, so problems with it
+// are not fatal.
+func (d *download) loadPartFile(path string) {
+	pieces, err := d.tf.readPartFile(path)
+	if err != nil {
+		d.log.Warn("could not read part file", slog.Any("error", err))
+		return
+	}
+	for index, piece := range pieces {
+		if err := d.torrent.AddPiece(index, piece); err != nil {
+			d.log.Debug("dropping piece from part file", slog.Int("piece", index), slog.Any("error", err))
 		}
+	}
+}
 
-		fullPath := filepath.Join(path, t.Name)
-		file, err := os.Create(fullPath)
-		if err != nil {
-			return err
+// requestPeers announces the start of the download to the swarm of
+// infoHash and returns its peers
+func (d *download) requestPeers(ctx context.Context, infoHash [20]byte) ([]peers.Peer, error) {
+	t, log := d.tf, d.log.With(slog.String("swarm", hex.EncodeToString(infoHash[:])))
+	req := d.req
+	req.infoHash = infoHash
+	start := time.Now()
+	found, err := t.requestPeers(ctx, d.http, req)
+	d.torrent.Events.Publish(client.TrackerAnnounce{
+		URL:      t.Announce,
+		Event:    eventStarted,
+		Peers:    len(found),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	if err != nil {
+		log.Error("tracker announce failed", slog.String("tracker", t.Announce), slog.Any("error", err))
+		return nil, err
+	}
+	log.Debug("tracker announce", slog.String("tracker", t.Announce), slog.Int("peers", len(found)))
+	return found, nil
+}
+
+// sendEvent tells the tracker about event in every swarm of the torrent
+// and returns the first error
+func (d *download) sendEvent(ctx context.Context, event string) error {
+	var firstErr error
+	for _, infoHash := range d.tf.infoHashes() {
+		req := d.req
+		req.infoHash = infoHash
+		start := time.Now()
+		err := d.tf.sendEvent(ctx, d.http, req, event)
+		d.torrent.Events.Publish(client.TrackerAnnounce{
+			URL:      d.tf.Announce,
+			Event:    event,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		if firstErr == nil {
+			firstErr = err
 		}
-		defer file.Close()
-		_, err = file.Write(buf)
-		if err != nil {
+	}
+	return firstErr
+}
+
+// saveToDisk writes the files of the torrent below path, after checking
+// that none of them ends up outside of it. Files with PrioritySkip in
+// priorities are not created, the parts of them that were downloaded
+// anyway go to the part file.
+func (t *TorrentFile) saveToDisk(src io.ReaderAt, path string, priorities []client.Priority, log *slog.Logger) error {
+	if err := t.checkPaths(); err != nil {
+		return err
+	}
+	skipped := func(i int) bool {
+		return priorities != nil && priorities[i] == client.PrioritySkip
+	}
+	if err := t.checkFileHashes(src, skipped); err != nil {
+		return err
+	}
+	if priorities != nil {
+		if err := os.MkdirAll(path, os.ModePerm); err != nil {
 			return err
 		}
-		return nil
-	}
-	fullPath := filepath.Join(path, t.Name)
-	startIndex := 0
-	curIndex := 0
-	buffer := bytes.NewReader(buf)
-	for _, f := range t.Files {
-		startIndex = curIndex
-		curIndex = startIndex + f.Length
-		curPath := filepath.Join(fullPath, filepath.Join(f.Path...))
-
-		err := os.MkdirAll(filepath.Dir(curPath), os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("failed to create directory: %w", err)
-		}
-
-		file, err := os.Create(curPath)
-		if err != nil {
+		if err := t.writePartFile(src, path, priorities); err != nil {
 			return err
 		}
-		sectionReader := io.NewSectionReader(buffer, int64(startIndex), int64(f.Length))
-		multiWriter := io.MultiWriter(file)
-		_, err = io.Copy(multiWriter, sectionReader)
-		file.Close()
-		if err != nil {
+	}
+	// The files of a single-file torrent are written to path itself
+	root := path
+	if len(t.Files) > 0 {
+		root = filepath.Join(path, t.Name)
+	}
+	for i := 0; i < t.numFiles(); i++ {
+		f := t.file(i)
+		if skipped(i) || f.padding() {
+			continue
+		}
+		begin, end := t.fileBounds(i)
+		if err := saveFile(root, f, io.NewSectionReader(src, int64(begin), int64(end-begin))); err != nil {
 			return err
 		}
-		log.Printf("Write to %s file \n", curPath)
+		log.Debug("wrote file", slog.String("path", filepath.Join(root, filepath.Join(f.Path...))))
 	}
 	return nil
 }
@@ -157,22 +415,53 @@ func (bto *bencodeTorrent) toTorrentFile(info_bytes []byte) (TorrentFile, error)
 	if err != nil {
 		return TorrentFile{}, err
 	}
+	// Lengths are checked before they are used in any sum, a sum that
+	// overflows would pass every later check
+	if bto.Info.PieceLength <= 0 || bto.Info.PieceLength > maxValidPieceLength {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", bto.Info.PieceLength)
+	}
 	length := 0
 	if len(bto.Info.Files) > 0 {
-		for _, f := range bto.Info.Files {
-			length += f.Length
+		for i, f := range bto.Info.Files {
+			if f.Length < 0 {
+				return TorrentFile{}, fmt.Errorf("file %d %q has negative length %d", i, f.Path, f.Length)
+			}
+			if length, err = addLength(length, f.Length); err != nil {
+				return TorrentFile{}, err
+			}
 		}
 	} else {
-		length += bto.Info.Length
+		length = bto.Info.Length
 	}
 	t := TorrentFile{
-		Announce:    bto.Announce,
-		InfoHash:    infoHash,
-		PieceHashes: pieceHashes,
-		PieceLength: bto.Info.PieceLength,
-		Length:      length,
-		Name:        bto.Info.Name,
-		Files:       bto.Info.Files,
+		Announce:     bto.Announce,
+		AnnounceList: bto.AnnounceList,
+		Comment:      bto.Comment,
+		CreatedBy:    bto.CreatedBy,
+		Encoding:     bto.Encoding,
+		InfoHash:     infoHash,
+		PieceHashes:  pieceHashes,
+		PieceLength:  bto.Info.PieceLength,
+		Length:       length,
+		Name:         bto.Info.Name,
+		Files:        bto.Info.Files,
+		Private:      bto.Info.Private == 1,
+		Source:       bto.Info.Source,
+		Attr:         bto.Info.Attr,
+		SHA1:         bto.Info.SHA1,
+		MetaVersion:  max(1, bto.Info.MetaVersion),
+		info:         info_bytes,
+	}
+	if bto.CreationDate != 0 {
+		t.CreationDate = time.Unix(int64(bto.CreationDate), 0).UTC()
+	}
+	if bto.Info.NameUTF8 != "" {
+		t.Name = bto.Info.NameUTF8
+	}
+	for i, f := range t.Files {
+		if len(f.PathUTF8) > 0 {
+			t.Files[i].Path = f.PathUTF8
+		}
 	}
 	return t, nil
 }
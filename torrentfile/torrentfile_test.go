@@ -1,10 +1,14 @@
 package torrentfile
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,13 +32,34 @@ func TestOpen(t *testing.T) {
 	err = json.Unmarshal(golden, &expected)
 	require.Nil(t, err)
 
+	// The raw metainfo kept for WriteTo and the file offsets are not part
+	// of the golden file
+	torrent.info, torrent.extra, torrent.offsets = nil, nil, nil
 	assert.Equal(t, expected, torrent)
 }
 
+func TestParseMetainfo(t *testing.T) {
+	in := "d7:comment4:note10:created by4:test13:creation datei1700000000e8:encoding5:UTF-8" +
+		"4:infod5:filesld6:lengthi3e6:md5sum32:0123456789abcdef0123456789abcdef4:pathl5:a.txte10:path.utf-8l6:\xc3\xa4.txteee" +
+		"4:name5:album10:name.utf-86:\xc3\xa4lbum12:piece lengthi4e6:pieces20:aaaaaaaaaaaaaaaaaaaa7:privatei1e6:source3:srcee"
+	tf, err := parse(strings.NewReader(in))
+	require.Nil(t, err)
+	assert.Equal(t, "note", tf.Comment)
+	assert.Equal(t, "test", tf.CreatedBy)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), tf.CreationDate)
+	assert.Equal(t, "UTF-8", tf.Encoding)
+	assert.True(t, tf.Private)
+	assert.Equal(t, "src", tf.Source)
+	assert.Equal(t, "\xc3\xa4lbum", tf.Name)
+	require.Len(t, tf.Files, 1)
+	assert.Equal(t, []string{"\xc3\xa4.txt"}, tf.Files[0].Path)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", tf.Files[0].MD5Sum)
+}
+
 func TestSaveDisk(t *testing.T) {
 	torrent, err := Open("testdata/KNOPPIX_V9.1CD-2021-01-25-EN.torrent")
 	require.Nil(t, err)
 	buf := make([]byte, torrent.Length)
-	err = torrent.saveToDisk(buf[:], "path")
+	err = torrent.saveToDisk(bytes.NewReader(buf[:]), "path", nil, slog.Default())
 	require.Nil(t, err)
 }
@@ -1,6 +1,11 @@
 package torrentfile
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -11,36 +16,93 @@ import (
 )
 
 type bencodeTrackerResp struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	FailureReason string `bencode:"failure reason"`
+	Interval      int    `bencode:"interval"`
+	Peers         string `bencode:"peers"`
 }
 
-func (t *TorrentFile) buildTrackerURL(peerID [20]byte, port uint16) (string, error) {
+// Tracker events sent with an announce. Regular re-announces carry no event.
+const (
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventStopped   = "stopped"
+)
+
+// announceRequest holds what we tell the tracker about ourselves
+type announceRequest struct {
+	peerID [20]byte
+	port   uint16
+	event  string
+	// ip is the address peers should connect to, nil lets the tracker
+	// use the address the request came from
+	ip net.IP
+	// key identifies us to the tracker when our IP address changes.
+	// Private trackers require it.
+	key string
+	// infoHash is the swarm announced to, the zero value means InfoHash
+	infoHash [20]byte
+}
+
+// newTrackerKey returns a random key for announceRequest
+func newTrackerKey() (string, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func (t *TorrentFile) buildTrackerURL(ar announceRequest) (string, error) {
 	base, err := url.Parse(t.Announce)
 	if err != nil {
 		return "", err
 	}
+	infoHash := ar.infoHash
+	if infoHash == ([20]byte{}) {
+		infoHash = t.InfoHash
+	}
 	params := url.Values{
-		"info_hash":  []string{string(t.InfoHash[:])},
-		"peer_id":    []string{string(peerID[:])},
-		"port":       []string{strconv.Itoa(int(port))},
+		"info_hash":  []string{string(infoHash[:])},
+		"peer_id":    []string{string(ar.peerID[:])},
+		"port":       []string{strconv.Itoa(int(ar.port))},
 		"uploaded":   []string{"0"},
 		"downloaded": []string{"0"},
 		"compact":    []string{"1"},
 		"left":       []string{strconv.Itoa(t.Length)},
 	}
+	if ar.event != "" {
+		params.Set("event", ar.event)
+	}
+	if ar.ip != nil {
+		params.Set("ip", ar.ip.String())
+	}
+	if ar.key != "" {
+		params.Set("key", ar.key)
+	}
 	base.RawQuery = params.Encode()
 	return base.String(), nil
 }
 
-func (t *TorrentFile) requestPeers(peerID [20]byte, port uint16) ([]peers.Peer, error) {
-	url, err := t.buildTrackerURL(peerID, port)
+// announce sends a request to the tracker with c, nil means a default client
+func (t *TorrentFile) announce(ctx context.Context, c *http.Client, ar announceRequest) (*http.Response, error) {
+	url, err := t.buildTrackerURL(ar)
 	if err != nil {
 		return nil, err
 	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		c = &http.Client{Timeout: 15 * time.Second}
+	}
+	return c.Do(req)
+}
 
-	c := &http.Client{Timeout: 15 * time.Second}
-	resp, err := c.Get(url)
+func (t *TorrentFile) requestPeers(ctx context.Context, c *http.Client, ar announceRequest) ([]peers.Peer, error) {
+	ar.event = eventStarted
+	resp, err := t.announce(ctx, c, ar)
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +117,21 @@ func (t *TorrentFile) requestPeers(peerID [20]byte, port uint16) ([]peers.Peer,
 	if err != nil {
 		return nil, err
 	}
+	if trackerResp.FailureReason != "" {
+		return nil, errors.New("tracker refused announce: " + trackerResp.FailureReason)
+	}
 
 	return peers.Unmarshal([]byte(trackerResp.Peers))
 }
+
+// sendEvent tells the tracker that the download has completed or stopped.
+// The response body carries nothing we need.
+func (t *TorrentFile) sendEvent(ctx context.Context, c *http.Client, ar announceRequest, event string) error {
+	ar.event = event
+	resp, err := t.announce(ctx, c, ar)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
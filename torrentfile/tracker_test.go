@@ -1,6 +1,7 @@
 package torrentfile
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -24,10 +25,50 @@ func TestBuildTrackerURL(t *testing.T) {
 	}
 	peerID := [20]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
 	const port uint16 = 6882
-	url, err := to.buildTrackerURL(peerID, port)
+	url, err := to.buildTrackerURL(announceRequest{peerID: peerID, port: port})
 	expected := "http://bttracker.debian.org:6969/announce?compact=1&downloaded=0&info_hash=%D8%F79%CE%C3%28%95l%CC%5B%BF%1F%86%D9%FD%CF%DB%A8%CE%B6&left=351272960&peer_id=%01%02%03%04%05%06%07%08%09%0A%0B%0C%0D%0E%0F%10%11%12%13%14&port=6882&uploaded=0"
 	assert.Nil(t, err)
 	assert.Equal(t, url, expected)
+
+	url, err = to.buildTrackerURL(announceRequest{peerID: peerID, port: port, ip: net.IPv4(192, 0, 2, 7)})
+	assert.Nil(t, err)
+	assert.Contains(t, url, "&ip=192.0.2.7&")
+
+	url, err = to.buildTrackerURL(announceRequest{peerID: peerID, port: port, key: "1a2b3c4d"})
+	assert.Nil(t, err)
+	assert.Contains(t, url, "&key=1a2b3c4d&")
+}
+
+func TestRequestPeersFailureReason(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason20:unregistered torrente"))
+	}))
+	defer ts.Close()
+	tf := TorrentFile{Announce: ts.URL, Length: 1}
+	_, err := tf.requestPeers(context.Background(), nil, announceRequest{port: 6882})
+	assert.ErrorContains(t, err, "unregistered torrent")
+}
+
+func TestDownloadSendsKey(t *testing.T) {
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.URL.Query().Get("key"))
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	defer ts.Close()
+	tf := newTestTorrentFile(ts.URL, "private", []byte("data"), 4)
+	tf.Private = true
+
+	d, err := tf.newDownload(newOptions(nil), [20]byte{}, 6882)
+	assert.Nil(t, err)
+	assert.Len(t, d.req.key, 8)
+	assert.Nil(t, d.sendEvent(context.Background(), eventStarted))
+	assert.Nil(t, d.sendEvent(context.Background(), eventStopped))
+	assert.Equal(t, []string{d.req.key, d.req.key}, keys)
+
+	other, err := tf.newDownload(newOptions(nil), [20]byte{}, 6882)
+	assert.Nil(t, err)
+	assert.NotEqual(t, d.req.key, other.req.key)
 }
 
 func TestRequestPeers(t *testing.T) {
@@ -60,7 +101,24 @@ func TestRequestPeers(t *testing.T) {
 		{IP: net.IP{192, 0, 2, 123}, Port: 6881},
 		{IP: net.IP{127, 0, 0, 1}, Port: 6889},
 	}
-	p, err := tf.requestPeers(peerID, port)
+	p, err := tf.requestPeers(context.Background(), nil, announceRequest{peerID: peerID, port: port})
 	assert.Nil(t, err)
 	assert.Equal(t, expected, p)
 }
+
+func TestSendEvent(t *testing.T) {
+	var gotEvent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEvent = r.URL.Query().Get("event")
+		w.Write([]byte("d8:intervali900ee"))
+	}))
+	defer ts.Close()
+	tf := TorrentFile{
+		Announce: ts.URL,
+		Length:   351272960,
+	}
+	peerID := [20]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	err := tf.sendEvent(context.Background(), nil, announceRequest{peerID: peerID, port: 6882}, eventStopped)
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", gotEvent)
+}
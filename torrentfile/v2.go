@@ -0,0 +1,253 @@
+package torrentfile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+	"github.com/parkma99/go-bittorrent-client/client"
+	"github.com/parkma99/go-bittorrent-client/merkle"
+)
+
+// treeFile is a file of the file tree of a v2 torrent
+type treeFile struct {
+	path       []string
+	length     int
+	piecesRoot [32]byte
+}
+
+// v2Only reports whether the torrent has no v1 piece hashes, so that its
+// files start at piece boundaries and its pieces are checked with their
+// merkle trees alone
+func (t *TorrentFile) v2Only() bool {
+	return t.MetaVersion == 2 && len(t.PieceHashes) == 0
+}
+
+// hybrid reports whether the torrent is both v1 and v2, and so in two
+// swarms
+func (t *TorrentFile) hybrid() bool {
+	return t.MetaVersion == 2 && len(t.PieceHashes) > 0
+}
+
+// infoHashes returns the info hashes of the swarms the torrent is in, the
+// v1 one first
+func (t *TorrentFile) infoHashes() [][20]byte {
+	hashes := [][20]byte{t.InfoHash}
+	if t.hybrid() {
+		hashes = append(hashes, [20]byte(t.InfoHashV2[:20]))
+	}
+	return hashes
+}
+
+// parseV2 reads the file tree and the piece layers of a v2 or hybrid
+// torrent (BEP 52) and checks every piece layer against the root of its
+// file. A torrent that is only v2 takes its files and info hash from them.
+func (t *TorrentFile) parseV2(dir map[string]*bencode.BObject) error {
+	if t.MetaVersion == 1 {
+		return nil
+	}
+	if t.MetaVersion != 2 {
+		return fmt.Errorf("unsupported meta version %d", t.MetaVersion)
+	}
+	if t.PieceLength < merkle.BlockSize || t.PieceLength != merkle.NextPowerOfTwo(t.PieceLength) {
+		return fmt.Errorf("piece length %d of a v2 torrent is not a power of two of at least %d", t.PieceLength, merkle.BlockSize)
+	}
+	info, err := dir["info"].Dict()
+	if err != nil {
+		return err
+	}
+	tree, ok := info["file tree"]
+	if !ok {
+		return errors.New("v2 torrent has no file tree")
+	}
+	files, err := parseFileTree(tree, nil, nil)
+	if err != nil {
+		return err
+	}
+	var layers map[string]*bencode.BObject
+	if o, ok := dir["piece layers"]; ok {
+		if layers, err = o.Dict(); err != nil {
+			return fmt.Errorf("invalid piece layers: %w", err)
+		}
+	}
+	t.FilesV2 = make([]client.FileV2, len(files))
+	for i, f := range files {
+		t.FilesV2[i] = client.FileV2{Length: f.length, PiecesRoot: f.piecesRoot}
+		if f.length <= t.PieceLength {
+			continue
+		}
+		layer, err := t.pieceLayer(f, layers)
+		if err != nil {
+			return err
+		}
+		t.FilesV2[i].PieceLayer = layer
+	}
+
+	t.InfoHashV2 = sha256.Sum256(t.info)
+	if t.hybrid() {
+		return t.checkHybrid(files)
+	}
+	copy(t.InfoHash[:], t.InfoHashV2[:])
+	t.Length = 0
+	if len(files) == 1 && slices.Equal(files[0].path, []string{t.Name}) {
+		t.Length = files[0].length
+		return nil
+	}
+	for _, f := range files {
+		t.Files = append(t.Files, fileInfo{Length: f.length, Path: f.path})
+		if t.Length, err = addLength(t.Length, f.length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHybrid checks that the v1 files of a hybrid torrent are the files
+// of its file tree, each padded to a piece boundary, so that both describe
+// the same pieces
+func (t *TorrentFile) checkHybrid(files []treeFile) error {
+	if len(t.Files) == 0 {
+		if len(files) != 1 || !slices.Equal(files[0].path, []string{t.Name}) || files[0].length != t.Length {
+			return errors.New("v1 and v2 files of hybrid torrent differ")
+		}
+		return nil
+	}
+	i := 0
+	offset := 0
+	for _, f := range t.Files {
+		if !f.padding() {
+			if i == len(files) || !slices.Equal(files[i].path, f.Path) || files[i].length != f.Length {
+				return errors.New("v1 and v2 files of hybrid torrent differ")
+			}
+			if offset%t.PieceLength != 0 {
+				return fmt.Errorf("file %q of hybrid torrent does not start at a piece boundary", f.Path)
+			}
+			i++
+		}
+		offset += f.Length
+	}
+	if i != len(files) {
+		return errors.New("v1 and v2 files of hybrid torrent differ")
+	}
+	return nil
+}
+
+// pieceLayer returns the piece layer of f from layers, after checking that
+// it leads to the pieces root of f
+func (t *TorrentFile) pieceLayer(f treeFile, layers map[string]*bencode.BObject) ([][32]byte, error) {
+	o, ok := layers[string(f.piecesRoot[:])]
+	if !ok {
+		return nil, fmt.Errorf("no piece layer for file %q", f.path)
+	}
+	s, err := o.Str()
+	if err != nil {
+		return nil, fmt.Errorf("invalid piece layer for file %q: %w", f.path, err)
+	}
+	count := (f.length + t.PieceLength - 1) / t.PieceLength
+	if len(s) != 32*count {
+		return nil, fmt.Errorf("piece layer for file %q has %d bytes, expected %d", f.path, len(s), 32*count)
+	}
+	layer := make([][32]byte, count)
+	for i := range layer {
+		copy(layer[i][:], s[32*i:])
+	}
+	pad := merkle.PadHash(merkle.Log2(t.PieceLength / merkle.BlockSize))
+	if merkle.Root(layer, merkle.NextPowerOfTwo(count), pad) != f.piecesRoot {
+		return nil, fmt.Errorf("piece layer for file %q does not match its pieces root", f.path)
+	}
+	return layer, nil
+}
+
+// parseFileTree appends the files below the directory o of the file tree
+// at path to files, in the order of their paths. A file is a dictionary
+// with the empty key only, holding its length and pieces root.
+func parseFileTree(o *bencode.BObject, path []string, files []treeFile) ([]treeFile, error) {
+	dict, err := o.Dict()
+	if err != nil {
+		return nil, fmt.Errorf("invalid file tree entry %q: %w", path, err)
+	}
+	if attrs, ok := dict[""]; ok {
+		if len(dict) != 1 || len(path) == 0 {
+			return nil, fmt.Errorf("file tree entry %q is both a file and a directory", path)
+		}
+		f, err := parseTreeFile(attrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file %q: %w", path, err)
+		}
+		f.path = path
+		return append(files, f), nil
+	}
+	names := make([]string, 0, len(dict))
+	for name := range dict {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := append(slices.Clip(path), name)
+		if files, err = parseFileTree(dict[name], child, files); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func parseTreeFile(o *bencode.BObject) (treeFile, error) {
+	var f treeFile
+	attrs, err := o.Dict()
+	if err != nil {
+		return f, err
+	}
+	length, ok := attrs["length"]
+	if !ok {
+		return f, errors.New("no length")
+	}
+	if f.length, err = length.Int(); err != nil {
+		return f, err
+	}
+	if f.length < 0 || f.length > maxValidLength {
+		return f, fmt.Errorf("invalid length %d", f.length)
+	}
+	if f.length == 0 {
+		return f, nil
+	}
+	root, ok := attrs["pieces root"]
+	if !ok {
+		return f, errors.New("no pieces root")
+	}
+	s, err := root.Str()
+	if err != nil {
+		return f, err
+	}
+	if len(s) != 32 {
+		return f, fmt.Errorf("pieces root has %d bytes", len(s))
+	}
+	copy(f.piecesRoot[:], s)
+	return f, nil
+}
+
+// alignFile returns where a file following offset begins. The files of a
+// v2 torrent start at piece boundaries.
+func (t *TorrentFile) alignFile(offset int) int {
+	if !t.v2Only() || offset%t.PieceLength == 0 {
+		return offset
+	}
+	return offset + t.PieceLength - offset%t.PieceLength
+}
+
+// bufferLength returns the number of bytes the files take up in the
+// download, which includes the padding between the files of a v2 torrent
+func (t *TorrentFile) bufferLength() int {
+	_, end := t.fileBounds(t.numFiles() - 1)
+	return end
+}
+
+// numPieces returns the number of pieces of the torrent
+func (t *TorrentFile) numPieces() int {
+	if !t.v2Only() {
+		return len(t.PieceHashes)
+	}
+	return (t.bufferLength() + t.PieceLength - 1) / t.PieceLength
+}
@@ -0,0 +1,295 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/merkle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode bencodes strings, ints, lists and dictionaries for hand-built
+// torrents
+func encode(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%d:%s", len(v), v)
+	case int:
+		return fmt.Sprintf("i%de", v)
+	case []any:
+		var b strings.Builder
+		for _, item := range v {
+			b.WriteString(encode(item))
+		}
+		return "l" + b.String() + "e"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		for _, key := range keys {
+			b.WriteString(encode(key) + encode(v[key]))
+		}
+		return "d" + b.String() + "e"
+	}
+	panic(fmt.Sprintf("cannot encode %T", v))
+}
+
+// testData returns n bytes that differ from block to block
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	return data
+}
+
+type testFileV2 struct {
+	path string
+	data []byte
+}
+
+// newTestMetainfoV2 returns the info dictionary and the piece layers of a
+// v2 torrent of files, whose paths are separated by slashes
+func newTestMetainfoV2(name string, files []testFileV2, pieceLength int) (info, layers map[string]any) {
+	tree := map[string]any{}
+	layers = map[string]any{}
+	for _, f := range files {
+		attrs := map[string]any{"length": len(f.data)}
+		if len(f.data) > 0 {
+			blocks := (len(f.data) + merkle.BlockSize - 1) / merkle.BlockSize
+			root := merkle.DataRoot(f.data, merkle.NextPowerOfTwo(blocks))
+			attrs["pieces root"] = string(root[:])
+			if len(f.data) > pieceLength {
+				var layer []byte
+				for begin := 0; begin < len(f.data); begin += pieceLength {
+					hash := merkle.DataRoot(f.data[begin:min(begin+pieceLength, len(f.data))], pieceLength/merkle.BlockSize)
+					layer = append(layer, hash[:]...)
+				}
+				layers[string(root[:])] = string(layer)
+			}
+		}
+		dir := tree
+		parts := strings.Split(f.path, "/")
+		for _, part := range parts[:len(parts)-1] {
+			if dir[part] == nil {
+				dir[part] = map[string]any{}
+			}
+			dir = dir[part].(map[string]any)
+		}
+		dir[parts[len(parts)-1]] = map[string]any{"": attrs}
+	}
+	info = map[string]any{
+		"file tree":    tree,
+		"meta version": 2,
+		"name":         name,
+		"piece length": pieceLength,
+	}
+	return info, layers
+}
+
+func parseTest(t *testing.T, torrent map[string]any) (TorrentFile, error) {
+	t.Helper()
+	return parse(strings.NewReader(encode(torrent)))
+}
+
+func TestParseV2(t *testing.T) {
+	const pieceLength = 2 * merkle.BlockSize
+	big, small := testData(70000), testData(1000)
+	info, layers := newTestMetainfoV2("v2", []testFileV2{
+		{"sub/big.bin", big},
+		{"a.txt", small},
+		{"empty", nil},
+	}, pieceLength)
+	tf, err := parseTest(t, map[string]any{"announce": "http://tracker", "info": info, "piece layers": layers})
+	require.Nil(t, err)
+
+	infoHash := sha256.Sum256([]byte(encode(info)))
+	assert.Equal(t, 2, tf.MetaVersion)
+	assert.Equal(t, infoHash, tf.InfoHashV2)
+	assert.Equal(t, infoHash[:20], tf.InfoHash[:])
+	assert.Empty(t, tf.PieceHashes)
+	assert.Equal(t, []fileInfo{
+		{Length: 1000, Path: []string{"a.txt"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 70000, Path: []string{"sub", "big.bin"}},
+	}, tf.Files)
+	assert.Equal(t, 71000, tf.Length)
+	require.Len(t, tf.FilesV2, 3)
+	assert.Nil(t, tf.FilesV2[0].PieceLayer)
+	assert.Len(t, tf.FilesV2[2].PieceLayer, 3)
+
+	// Every file starts at a piece boundary
+	begin, end := tf.fileBounds(2)
+	assert.Equal(t, pieceLength, begin)
+	assert.Equal(t, pieceLength+70000, end)
+	assert.Equal(t, pieceLength+70000, tf.bufferLength())
+	assert.Equal(t, 4, tf.numPieces())
+	begin, end = tf.calculateBoundsForPiece(0)
+	assert.Equal(t, 0, begin)
+	assert.Equal(t, 1000, end)
+}
+
+func TestParseV2SingleFile(t *testing.T) {
+	data := testData(40000)
+	info, layers := newTestMetainfoV2("one.bin", []testFileV2{{"one.bin", data}}, merkle.BlockSize)
+	tf, err := parseTest(t, map[string]any{"info": info, "piece layers": layers})
+	require.Nil(t, err)
+	assert.Nil(t, tf.Files)
+	assert.Equal(t, 40000, tf.Length)
+	assert.Equal(t, 3, tf.numPieces())
+}
+
+func TestParseV2Invalid(t *testing.T) {
+	data := testData(70000)
+	newTorrent := func() (map[string]any, map[string]any) {
+		info, layers := newTestMetainfoV2("v2", []testFileV2{{"f", data}}, merkle.BlockSize)
+		return map[string]any{"info": info, "piece layers": layers}, info
+	}
+
+	torrent, _ := newTorrent()
+	delete(torrent, "piece layers")
+	_, err := parseTest(t, torrent)
+	assert.ErrorContains(t, err, "no piece layer")
+
+	torrent, _ = newTorrent()
+	for root, layer := range torrent["piece layers"].(map[string]any) {
+		corrupt := []byte(layer.(string))
+		corrupt[0] ^= 1
+		torrent["piece layers"].(map[string]any)[root] = string(corrupt)
+	}
+	_, err = parseTest(t, torrent)
+	assert.ErrorContains(t, err, "does not match")
+
+	torrent, info := newTorrent()
+	info["piece length"] = 3 * merkle.BlockSize
+	_, err = parseTest(t, torrent)
+	assert.ErrorContains(t, err, "power of two")
+
+	torrent, info = newTorrent()
+	info["meta version"] = 3
+	_, err = parseTest(t, torrent)
+	assert.ErrorContains(t, err, "meta version")
+
+	torrent, info = newTorrent()
+	info["file tree"] = map[string]any{"f": map[string]any{"": map[string]any{"length": 1}, "g": map[string]any{}}}
+	_, err = parseTest(t, torrent)
+	assert.ErrorContains(t, err, "both a file and a directory")
+
+	torrent, info = newTorrent()
+	info["file tree"] = map[string]any{"f": map[string]any{"": map[string]any{"length": math.MaxInt, "pieces root": strings.Repeat("r", 32)}}}
+	_, err = parseTest(t, torrent)
+	assert.ErrorContains(t, err, "invalid length")
+}
+
+func TestDownloadV2FromWebSeed(t *testing.T) {
+	files := []testFileV2{
+		{"one.bin", testData(30000)},
+		{"sub/two.bin", testData(40000)},
+	}
+	info, layers := newTestMetainfoV2("mirrored", files, merkle.BlockSize)
+	tracker := newTestTracker(t)
+	mirror := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(mirror, "mirrored", filepath.FromSlash(f.path))
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, os.WriteFile(path, f.data, 0644))
+	}
+	ts := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	defer ts.Close()
+	tf, err := parseTest(t, map[string]any{
+		"announce":     tracker.URL,
+		"info":         info,
+		"piece layers": layers,
+		"url-list":     ts.URL + "/",
+	})
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out := t.TempDir()
+	require.Nil(t, tf.DownloadToFile(ctx, out))
+	for _, f := range files {
+		written, err := os.ReadFile(filepath.Join(out, "mirrored", filepath.FromSlash(f.path)))
+		require.Nil(t, err)
+		assert.True(t, bytes.Equal(f.data, written), f.path)
+	}
+}
+
+func TestParseHybridMismatch(t *testing.T) {
+	data := testData(1000)
+	info, layers := newTestMetainfoV2("v2", []testFileV2{{"a", data}}, merkle.BlockSize)
+	hash := sha1.Sum(data)
+	info["pieces"] = string(hash[:])
+	info["files"] = []any{map[string]any{"length": 999, "path": []any{"a"}}}
+	_, err := parseTest(t, map[string]any{"info": info, "piece layers": layers})
+	assert.ErrorContains(t, err, "differ")
+
+	info["files"] = []any{map[string]any{"length": 1000, "path": []any{"a"}}}
+	tf, err := parseTest(t, map[string]any{"info": info, "piece layers": layers})
+	require.Nil(t, err)
+	assert.True(t, tf.hybrid())
+	assert.Equal(t, hash, tf.PieceHashes[0])
+}
+
+func TestDownloadHybridFromWebSeed(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "mixed")
+	require.Nil(t, os.MkdirAll(filepath.Join(root, "sub"), 0755))
+	one, two := testData(30000), testData(40000)
+	require.Nil(t, os.WriteFile(filepath.Join(root, "one.bin"), one, 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "sub", "two.bin"), two, 0644))
+	ts := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(root))))
+	defer ts.Close()
+
+	// The tracker sees an announce for each swarm
+	var mu sync.Mutex
+	swarms := make(map[string]bool)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		swarms[r.URL.Query().Get("info_hash")] = true
+		mu.Unlock()
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	var metainfo bytes.Buffer
+	tf, err := Create(&metainfo, root, CreateOptions{
+		Announce:    tracker.URL,
+		Hybrid:      true,
+		PieceLength: merkle.BlockSize,
+		URLList:     []string{ts.URL + "/"},
+	})
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out := t.TempDir()
+	require.Nil(t, tf.DownloadToFile(ctx, out))
+	written, err := os.ReadFile(filepath.Join(out, "mixed", "sub", "two.bin"))
+	require.Nil(t, err)
+	assert.True(t, bytes.Equal(two, written))
+	_, err = os.Stat(filepath.Join(out, "mixed", ".pad"))
+	assert.True(t, os.IsNotExist(err))
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, infoHash := range tf.infoHashes() {
+		assert.True(t, swarms[string(infoHash[:])])
+	}
+}
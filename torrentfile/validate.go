@@ -0,0 +1,113 @@
+package torrentfile
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+	"github.com/parkma99/go-bittorrent-client/merkle"
+)
+
+const (
+	// maxValidPieceLength is the longest piece accepted, far above what
+	// any client creates
+	maxValidPieceLength = 1 << 28
+	// maxValidLength is the most bytes a torrent may hold, which leaves
+	// room for the padding between the files of a v2 torrent
+	maxValidLength = math.MaxInt / 2
+)
+
+// addLength returns total plus the length n of a file, failing when that
+// is more than maxValidLength
+func addLength(total, n int) (int, error) {
+	if n > maxValidLength-total {
+		return 0, fmt.Errorf("torrent is longer than %d bytes", maxValidLength)
+	}
+	return total + n, nil
+}
+
+// validate checks that the metainfo is consistent, info being the info
+// dictionary. A torrent failing it would make the download panic or
+// corrupt the files.
+func (t *TorrentFile) validate(info map[string]*bencode.BObject) error {
+	_, hasLength := info["length"]
+	_, hasFiles := info["files"]
+	if hasLength && hasFiles {
+		return errors.New("info has both length and files")
+	}
+	if !hasLength && !hasFiles && !t.v2Only() {
+		return errors.New("info has neither length nor files")
+	}
+	if hasFiles && len(t.Files) == 0 {
+		return errors.New("info has an empty list of files")
+	}
+	if t.Length < 0 {
+		return fmt.Errorf("negative length %d", t.Length)
+	}
+	if t.Length > maxValidLength {
+		return fmt.Errorf("torrent is longer than %d bytes", maxValidLength)
+	}
+	for i, f := range t.Files {
+		if f.SHA1 != "" && len(f.SHA1) != 20 {
+			return fmt.Errorf("file %d %q has a sha1 hash of %d bytes", i, f.Path, len(f.SHA1))
+		}
+	}
+	if t.v2Only() {
+		return nil
+	}
+	want := (t.Length + t.PieceLength - 1) / t.PieceLength
+	if len(t.PieceHashes) != want {
+		return fmt.Errorf("torrent has %d pieces, %d bytes in pieces of %d need %d",
+			len(t.PieceHashes), t.Length, t.PieceLength, want)
+	}
+	return nil
+}
+
+// Lint returns warnings about things that are legal in a torrent but
+// unusual, or that keep it from working well
+func (t *TorrentFile) Lint() []string {
+	var warnings []string
+	warnf := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	if t.PieceLength != merkle.NextPowerOfTwo(t.PieceLength) {
+		warnf("piece length %d is not a power of two", t.PieceLength)
+	}
+	if t.PieceLength < minPieceLength || t.PieceLength > maxPieceLength {
+		warnf("piece length %d is outside of %d to %d", t.PieceLength, minPieceLength, maxPieceLength)
+	}
+	var trackers []string
+	if t.Announce != "" {
+		trackers = append(trackers, t.Announce)
+	}
+	for _, tier := range t.AnnounceList {
+		trackers = append(trackers, tier...)
+	}
+	if len(trackers) == 0 {
+		if t.Private {
+			warnf("private torrent has no announce, it cannot find peers")
+		} else {
+			warnf("no announce, peers can only be found through web seeds or incoming connections")
+		}
+	}
+	for _, tracker := range trackers {
+		u, err := url.Parse(tracker)
+		if err != nil {
+			warnf("tracker %q is not a URL: %v", tracker, err)
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https":
+		case "udp":
+			warnf("tracker %q uses UDP, which is not supported", tracker)
+		default:
+			warnf("tracker %q has unknown scheme %q", tracker, u.Scheme)
+		}
+	}
+	if t.Length == 0 {
+		warnf("torrent holds no data")
+	}
+	return warnings
+}
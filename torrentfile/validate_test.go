@@ -0,0 +1,63 @@
+package torrentfile
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	pieces := func(n int) string { return strings.Repeat("a", 20*n) }
+	file := func(length int, name string) map[string]any {
+		return map[string]any{"length": length, "path": []any{name}}
+	}
+	tests := []struct {
+		name string
+		info map[string]any
+		err  string
+	}{
+		{"valid", map[string]any{"length": 10, "piece length": 4, "pieces": pieces(3)}, ""},
+		{"both", map[string]any{"length": 10, "files": []any{file(10, "a")}, "piece length": 4, "pieces": pieces(3)}, "both length and files"},
+		{"neither", map[string]any{"piece length": 4, "pieces": pieces(0)}, "neither length nor files"},
+		{"no files", map[string]any{"files": []any{}, "piece length": 4, "pieces": pieces(0)}, "empty list of files"},
+		{"zero piece length", map[string]any{"length": 10, "piece length": 0, "pieces": pieces(3)}, "invalid piece length 0"},
+		{"negative piece length", map[string]any{"length": 10, "piece length": -4, "pieces": pieces(3)}, "invalid piece length -4"},
+		{"negative length", map[string]any{"length": -10, "piece length": 4, "pieces": pieces(0)}, "negative length -10"},
+		{"negative file", map[string]any{"files": []any{file(14, "a"), file(-4, "b")}, "piece length": 4, "pieces": pieces(3)}, `file 1 ["b"] has negative length -4`},
+		{"too few pieces", map[string]any{"length": 10, "piece length": 4, "pieces": pieces(2)}, "torrent has 2 pieces, 10 bytes in pieces of 4 need 3"},
+		{"too many pieces", map[string]any{"files": []any{file(4, "a"), file(4, "b")}, "piece length": 4, "pieces": pieces(3)}, "torrent has 3 pieces, 8 bytes in pieces of 4 need 2"},
+		// The lengths would wrap around to 1, which one piece covers
+		{"overflow", map[string]any{"files": []any{file(math.MaxInt, "a"), file(math.MaxInt, "b"), file(3, "c")}, "piece length": 4, "pieces": pieces(1)}, "torrent is longer than"},
+		{"too long", map[string]any{"length": math.MaxInt, "piece length": 1 << 28, "pieces": pieces(1)}, "torrent is longer than"},
+		{"huge piece length", map[string]any{"length": 10, "piece length": 1 << 29, "pieces": pieces(1)}, "invalid piece length 536870912"},
+	}
+	for _, test := range tests {
+		test.info["name"] = "t"
+		_, err := parseTest(t, map[string]any{"info": test.info})
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.ErrorContains(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	info := map[string]any{"length": 10, "name": "t", "piece length": 3, "pieces": strings.Repeat("a", 80)}
+	tf, err := parseTest(t, map[string]any{"info": info})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"piece length 3 is not a power of two",
+		"piece length 3 is outside of 16384 to 16777216",
+		"no announce, peers can only be found through web seeds or incoming connections",
+	}, tf.Lint())
+
+	tf, err = Open("testdata/debian-12.1.0-amd64-netinst.iso.torrent")
+	require.NoError(t, err)
+	assert.Empty(t, tf.Lint())
+	tf.Announce = "udp://tracker.example:6969"
+	assert.Equal(t, []string{`tracker "udp://tracker.example:6969" uses UDP, which is not supported`}, tf.Lint())
+}
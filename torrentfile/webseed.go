@@ -0,0 +1,159 @@
+package torrentfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+)
+
+const (
+	// seedRequestTimeout bounds a request to a web seed, on top of the
+	// time the transfer takes at minSeedRate
+	seedRequestTimeout = 15 * time.Second
+	// minSeedRate is the slowest rate in bytes per second that a web seed
+	// is given to send a piece
+	minSeedRate = 16 << 10
+)
+
+// webSeed fetches pieces from a BEP 19 web seed, a plain HTTP server that
+// hosts the files of the torrent
+type webSeed struct {
+	url    string
+	tf     *TorrentFile
+	client *http.Client
+	// timeout bounds every request, see seedTimeout
+	timeout time.Duration
+	// bounds holds the byte range of every file
+	bounds [][2]int
+}
+
+func newWebSeed(u string, tf *TorrentFile, client *http.Client) *webSeed {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ws := &webSeed{url: u, tf: tf, client: client, timeout: seedRequestTimeout}
+	for i := 0; i < tf.numFiles(); i++ {
+		begin, end := tf.fileBounds(i)
+		ws.bounds = append(ws.bounds, [2]int{begin, end})
+	}
+	return ws
+}
+
+func (ws *webSeed) String() string {
+	return ws.url
+}
+
+// fileURL returns where file i lives. For a single-file torrent a URL
+// ending in a slash is a directory holding the file, any other URL is
+// the file itself. For a multi-file torrent the URL is always the
+// directory holding the torrent's directory.
+func (ws *webSeed) fileURL(i int) string {
+	parts := []string{ws.tf.Name}
+	if len(ws.tf.Files) == 0 {
+		if !strings.HasSuffix(ws.url, "/") {
+			return ws.url
+		}
+	} else {
+		parts = append(parts, ws.tf.Files[i].Path...)
+	}
+	for j, part := range parts {
+		parts[j] = url.PathEscape(part)
+	}
+	return strings.TrimSuffix(ws.url, "/") + "/" + strings.Join(parts, "/")
+}
+
+// FetchPiece requests the part of every file the piece spans. A stalled
+// server fails the piece once seedTimeout has passed, so that it goes back
+// to the other sources.
+func (ws *webSeed) FetchPiece(ctx context.Context, index, begin, length int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, seedTimeout(ws.timeout, length))
+	defer cancel()
+	buf := make([]byte, 0, length)
+	end := begin + length
+	for i, bounds := range ws.bounds {
+		fileBegin, fileEnd := bounds[0], bounds[1]
+		if fileEnd <= begin || fileBegin >= end || fileBegin == fileEnd {
+			continue
+		}
+		from, to := max(begin, fileBegin), min(end, fileEnd)
+		if ws.tf.isPadding(i) {
+			// Padding files are zeros and not on the server
+			buf = append(buf, make([]byte, to-from)...)
+			continue
+		}
+		var err error
+		buf, err = ws.fetchRange(ctx, ws.fileURL(i), from-fileBegin, to-from, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(buf) != length {
+		return nil, fmt.Errorf("web seed returned %d bytes of piece %d, expected %d", len(buf), index, length)
+	}
+	return buf, nil
+}
+
+// seedTimeout returns how long a request for length bytes may take: the
+// base timeout plus the transfer at minSeedRate
+func seedTimeout(base time.Duration, length int) time.Duration {
+	return base + time.Duration(length)*time.Second/minSeedRate
+}
+
+// fetchRange appends n bytes at offset off of the file at u to buf
+func (ws *webSeed) fetchRange(ctx context.Context, u string, off, n int, buf []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range and sends the whole file
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(off)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("web seed answered %s", resp.Status)
+	}
+	start := len(buf)
+	buf = buf[:start+n]
+	if _, err := io.ReadFull(resp.Body, buf[start:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// stringList reads a value that may be a single string or a list of
+// strings, as url-list is
+func stringList(o *bencode.BObject) []string {
+	if s, err := o.Str(); err == nil {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	list, err := o.List()
+	if err != nil {
+		return nil
+	}
+	var strs []string
+	for _, item := range list {
+		if s, err := item.Str(); err == nil && s != "" {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,105 @@
+package torrentfile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSeedFileURL(t *testing.T) {
+	single := &TorrentFile{Name: "a b.iso", Length: 10}
+	multi := &TorrentFile{Name: "dir", Length: 10, Files: []fileInfo{
+		{Length: 10, Path: []string{"sub", "c#d.txt"}},
+	}}
+	tests := []struct {
+		tf       *TorrentFile
+		url      string
+		expected string
+	}{
+		{single, "http://mirror/pub/file.iso", "http://mirror/pub/file.iso"},
+		{single, "http://mirror/pub/", "http://mirror/pub/a%20b.iso"},
+		{multi, "http://mirror/pub", "http://mirror/pub/dir/sub/c%23d.txt"},
+		{multi, "http://mirror/pub/", "http://mirror/pub/dir/sub/c%23d.txt"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, newWebSeed(test.url, test.tf, nil).fileURL(0))
+	}
+}
+
+func TestDownloadFromWebSeed(t *testing.T) {
+	data := make([]byte, 70000)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	tracker := newTestTracker(t)
+	tf := newTestTorrentFile(tracker.URL, "mirrored", data, 16384)
+	tf.Files = []fileInfo{
+		{Length: 30000, Path: []string{"one.bin"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 40000, Path: []string{"sub", "two.bin"}},
+	}
+
+	// The mirror hosts the same files, FileServer answers range requests
+	mirror := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(mirror, "mirrored", "sub"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(mirror, "mirrored", "one.bin"), data[:30000], 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(mirror, "mirrored", "empty"), nil, 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(mirror, "mirrored", "sub", "two.bin"), data[30000:], 0644))
+	ts := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	defer ts.Close()
+	tf.URLList = []string{ts.URL + "/"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out := t.TempDir()
+	require.Nil(t, tf.DownloadToFile(ctx, out))
+
+	written, err := os.ReadFile(filepath.Join(out, "mirrored", "sub", "two.bin"))
+	require.Nil(t, err)
+	assert.Equal(t, data[30000:], written)
+}
+
+func TestWebSeedWithoutRanges(t *testing.T) {
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+	tf := newTestTorrentFile("", "plain.bin", data, 2048)
+
+	ws := newWebSeed(ts.URL+"/plain.bin", tf, nil)
+	piece, err := ws.FetchPiece(context.Background(), 2, 4096, 904)
+	require.Nil(t, err)
+	assert.Equal(t, data[4096:], piece)
+}
+
+func TestWebSeedTimeout(t *testing.T) {
+	data := testData(1000)
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stalled:
+		}
+	}))
+	defer ts.Close()
+	defer close(stalled)
+	tf := newTestTorrentFile("", "plain.bin", data, 1024)
+
+	ws := newWebSeed(ts.URL+"/plain.bin", tf, nil)
+	ws.timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := ws.FetchPiece(context.Background(), 0, 0, len(data))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,127 @@
+package torrentfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/parkma99/go-bittorrent-client/bencode"
+)
+
+// knownKeys are the top-level keys TorrentFile has fields for. WriteTo
+// writes them from the fields and copies all other keys as they were read.
+var knownKeys = map[string]bool{
+	"announce":      true,
+	"announce-list": true,
+	"comment":       true,
+	"created by":    true,
+	"creation date": true,
+	"encoding":      true,
+	"httpseeds":     true,
+	"info":          true,
+	"url-list":      true,
+}
+
+// WriteTo writes the metainfo of the torrent to w. The info dictionary is
+// written exactly as it was read, so the info hash does not change when
+// trackers, the comment, web seeds or other fields outside of the info
+// dictionary are edited. Keys TorrentFile does not know are kept.
+func (t *TorrentFile) WriteTo(w io.Writer) (int64, error) {
+	if t.info == nil {
+		return 0, errors.New("torrent has no info dictionary to write")
+	}
+	values := make(map[string][]byte, len(t.extra)+len(knownKeys))
+	for key, value := range t.extra {
+		values[key] = value.Raw()
+	}
+	values["info"] = t.info
+	set := func(key string, value interface{}) error {
+		var buf bytes.Buffer
+		if _, err := bencode.Marshal(&buf, value); err != nil {
+			return err
+		}
+		values[key] = buf.Bytes()
+		return nil
+	}
+	fields := []struct {
+		key   string
+		value interface{}
+		set   bool
+	}{
+		{"announce", t.Announce, t.Announce != ""},
+		{"announce-list", t.AnnounceList, len(t.AnnounceList) > 0},
+		{"comment", t.Comment, t.Comment != ""},
+		{"created by", t.CreatedBy, t.CreatedBy != ""},
+		{"creation date", int(t.CreationDate.Unix()), !t.CreationDate.IsZero()},
+		{"encoding", t.Encoding, t.Encoding != ""},
+		{"httpseeds", t.HTTPSeeds, len(t.HTTPSeeds) > 0},
+		{"url-list", t.URLList, len(t.URLList) > 0},
+	}
+	for _, f := range fields {
+		if !f.set {
+			continue
+		}
+		if err := set(f.key, f.value); err != nil {
+			return 0, err
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.WriteByte('d')
+	for _, key := range keys {
+		bencode.EncodeString(&buf, key)
+		buf.Write(values[key])
+	}
+	buf.WriteByte('e')
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Save writes the metainfo of the torrent to the file at path, replacing
+// it only once the whole torrent is written. A replaced file keeps its
+// mode, a new one is created as with os.Create.
+func (t *TorrentFile) Save(path string) error {
+	f, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := t.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// createTemp creates a hidden file next to path to be renamed over it.
+// Unlike os.CreateTemp it leaves the permissions to the umask.
+func createTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
@@ -0,0 +1,102 @@
+package torrentfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToUnchanged(t *testing.T) {
+	for _, name := range []string{
+		"testdata/debian-12.1.0-amd64-netinst.iso.torrent",
+		"testdata/KNOPPIX_V9.1CD-2021-01-25-EN.torrent",
+	} {
+		raw, err := os.ReadFile(name)
+		require.NoError(t, err)
+		tf, err := Open(name)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		n, err := tf.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+		assert.Equal(t, raw, buf.Bytes(), name)
+	}
+}
+
+func TestSaveEdited(t *testing.T) {
+	tf, err := Open("testdata/debian-12.1.0-amd64-netinst.iso.torrent")
+	require.NoError(t, err)
+	tf.Announce = "http://tracker.example/announce"
+	tf.AnnounceList = [][]string{{"http://tracker.example/announce"}, {"http://backup.example/announce"}}
+	tf.Comment = "edited"
+	tf.URLList = nil
+	tf.HTTPSeeds = []string{"http://seed.example/"}
+
+	path := filepath.Join(t.TempDir(), "edited.torrent")
+	require.NoError(t, tf.Save(path))
+	edited, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, tf.InfoHash, edited.InfoHash)
+	assert.Equal(t, tf.Announce, edited.Announce)
+	assert.Equal(t, tf.AnnounceList, edited.AnnounceList)
+	assert.Equal(t, "edited", edited.Comment)
+	assert.Nil(t, edited.URLList)
+	assert.Equal(t, tf.HTTPSeeds, edited.HTTPSeeds)
+	assert.Equal(t, "mktorrent 1.1", edited.CreatedBy)
+	assert.Equal(t, tf.CreationDate, edited.CreationDate)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestSaveKeepsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes need a Unix file system")
+	}
+	tf, err := Open("testdata/debian-12.1.0-amd64-netinst.iso.torrent")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "kept.torrent")
+	require.NoError(t, os.WriteFile(path, nil, 0o640))
+	require.NoError(t, os.Chmod(path, 0o640))
+	require.NoError(t, tf.Save(path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	// A new file gets the umask applied like any other, which is never
+	// the 0600 of a temporary file unless the umask asks for it
+	path = filepath.Join(filepath.Dir(path), "new.torrent")
+	require.NoError(t, tf.Save(path))
+	probe := filepath.Join(filepath.Dir(path), "probe")
+	require.NoError(t, os.WriteFile(probe, nil, 0o666))
+	want, err := os.Stat(probe)
+	require.NoError(t, err)
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, want.Mode().Perm(), info.Mode().Perm())
+}
+
+func TestWriteToKeepsUnknownKeys(t *testing.T) {
+	in := "d8:announce5:old/a4:infod6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:aaaaaaaaaaaaaaaaaaaae7:unknownli1ei2eee"
+	tf, err := parse(bytes.NewReader([]byte(in)))
+	require.NoError(t, err)
+	tf.Announce = "new/a"
+
+	var buf bytes.Buffer
+	_, err = tf.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Replace(in, "5:old/a", "5:new/a", 1), buf.String())
+}
+
+func TestWriteToWithoutInfo(t *testing.T) {
+	tf := newTestTorrentFile("", "test", []byte("data"), 4)
+	_, err := tf.WriteTo(&bytes.Buffer{})
+	assert.Error(t, err)
+}